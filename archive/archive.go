@@ -0,0 +1,317 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Keeps the raw leaf_input and extra_data of downloaded log entries, so they
+// can be re-ingested without going back to the log.
+//
+// Each log has its own directory of segment files. A segment is a series of
+// gzip members, one per batch of consecutive entries, and has a companion
+// index file listing where each batch starts, so any range can be read back
+// without decompressing the whole segment.
+
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/certificate-transparency/go/client"
+)
+
+// Segments are rolled over once they grow past this many bytes
+const MaxSegmentSize = 256 * 1024 * 1024
+
+const (
+	segmentSuffix   = ".seg"
+	indexSuffix     = ".idx"
+	indexRecordSize = 24
+)
+
+// Block is one batch of consecutive entries within a segment
+type Block struct {
+	Start   uint64 // Log index of the first entry
+	Count   uint64 // Number of entries
+	Offset  int64  // Byte offset of the gzip member within the segment
+	segment string
+}
+
+func (b Block) End() uint64 {
+	return b.Start + b.Count
+}
+
+type blocksByStart []Block
+
+func (b blocksByStart) Len() int           { return len(b) }
+func (b blocksByStart) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b blocksByStart) Less(i, j int) bool { return b[i].Start < b[j].Start }
+
+type segmentWriter struct {
+	segment *os.File
+	index   *os.File
+	size    int64
+}
+
+// rollback discards whatever a failed write left at the ends of the segment
+// and its index, so that later offsets stay correct. Should the segment not
+// truncate, its size is re-read instead.
+func (sw *segmentWriter) rollback() {
+	if err := sw.segment.Truncate(sw.size); err != nil {
+		if stat, statErr := sw.segment.Stat(); statErr == nil {
+			sw.size = stat.Size()
+		}
+	}
+	if stat, err := sw.index.Stat(); err == nil {
+		sw.index.Truncate(stat.Size() - stat.Size()%indexRecordSize)
+	}
+}
+
+func (sw *segmentWriter) Close() error {
+	err := sw.segment.Close()
+	if idxErr := sw.index.Close(); err == nil {
+		err = idxErr
+	}
+	return err
+}
+
+type Archive struct {
+	rootDir string
+	writers map[int]*segmentWriter
+	lock    sync.Mutex
+}
+
+func New(aPath string) (*Archive, error) {
+	err := os.MkdirAll(aPath, os.ModeDir|0777)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{
+		rootDir: aPath,
+		writers: make(map[int]*segmentWriter),
+	}, nil
+}
+
+func (a *Archive) logDir(logID int) string {
+	return filepath.Join(a.rootDir, strconv.Itoa(logID))
+}
+
+// openSegment starts a segment named for the first index it holds. Should a
+// segment of that name already exist, from downloading the same range
+// before, it is appended to; the index keeps track of both.
+func (a *Archive) openSegment(logID int, start uint64) (*segmentWriter, error) {
+	dir := a.logDir(logID)
+	err := os.MkdirAll(dir, os.ModeDir|0777)
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%020d", start))
+	segment, err := os.OpenFile(base+segmentSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(base+indexSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		segment.Close()
+		return nil, err
+	}
+	stat, err := segment.Stat()
+	if err != nil {
+		segment.Close()
+		index.Close()
+		return nil, err
+	}
+	return &segmentWriter{segment: segment, index: index, size: stat.Size()}, nil
+}
+
+// Append stores a batch of consecutive entries, the first of which is at
+// index start. Does nothing on a nil Archive.
+func (a *Archive) Append(logID int, start uint64, entries []client.LeafEntry) error {
+	if a == nil || len(entries) == 0 {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	sw, ok := a.writers[logID]
+	if ok && sw.size >= MaxSegmentSize {
+		if err := sw.Close(); err != nil {
+			return err
+		}
+		delete(a.writers, logID)
+		ok = false
+	}
+	if !ok {
+		var err error
+		sw, err = a.openSegment(logID, start)
+		if err != nil {
+			return err
+		}
+		a.writers[logID] = sw
+	}
+
+	var member bytes.Buffer
+	gzWriter := gzip.NewWriter(&member)
+	for _, entry := range entries {
+		writeRecord(gzWriter, entry.LeafInput)
+		writeRecord(gzWriter, entry.ExtraData)
+	}
+	if err := gzWriter.Close(); err != nil {
+		return err
+	}
+
+	if _, err := sw.segment.Write(member.Bytes()); err != nil {
+		sw.rollback()
+		return err
+	}
+
+	// The index is only written once the member is complete, so a crash
+	// can't leave it pointing at a partial one
+	indexRecord := make([]byte, indexRecordSize)
+	binary.BigEndian.PutUint64(indexRecord[0:], start)
+	binary.BigEndian.PutUint64(indexRecord[8:], uint64(len(entries)))
+	binary.BigEndian.PutUint64(indexRecord[16:], uint64(sw.size))
+	if _, err := sw.index.Write(indexRecord); err != nil {
+		sw.rollback()
+		return err
+	}
+
+	sw.size += int64(member.Len())
+	return nil
+}
+
+// Close finishes every open segment. Does nothing on a nil Archive.
+func (a *Archive) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var err error
+	for logID, sw := range a.writers {
+		if closeErr := sw.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(a.writers, logID)
+	}
+	return err
+}
+
+// Logs lists the IDs of every log with archived entries.
+func (a *Archive) Logs() ([]int, error) {
+	files, err := ioutil.ReadDir(a.rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var logIDs []int
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		logID, err := strconv.Atoi(file.Name())
+		if err != nil {
+			continue
+		}
+		logIDs = append(logIDs, logID)
+	}
+	sort.Ints(logIDs)
+	return logIDs, nil
+}
+
+// Blocks reads the indices of every segment of the log, ordered by the index
+// of their first entry.
+func (a *Archive) Blocks(logID int) ([]Block, error) {
+	dir := a.logDir(logID)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []Block
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), indexSuffix) {
+			continue
+		}
+		base := filepath.Join(dir, strings.TrimSuffix(file.Name(), indexSuffix))
+		data, err := ioutil.ReadFile(base + indexSuffix)
+		if err != nil {
+			return nil, err
+		}
+		// A trailing partial record is a write that never finished
+		for i := 0; i+indexRecordSize <= len(data); i += indexRecordSize {
+			blocks = append(blocks, Block{
+				Start:   binary.BigEndian.Uint64(data[i:]),
+				Count:   binary.BigEndian.Uint64(data[i+8:]),
+				Offset:  int64(binary.BigEndian.Uint64(data[i+16:])),
+				segment: base + segmentSuffix,
+			})
+		}
+	}
+
+	sort.Stable(blocksByStart(blocks))
+	return blocks, nil
+}
+
+// ReadBlock decompresses every entry in the block.
+func (a *Archive) ReadBlock(block Block) ([]client.LeafEntry, error) {
+	segment, err := os.Open(block.segment)
+	if err != nil {
+		return nil, err
+	}
+	defer segment.Close()
+
+	if _, err = segment.Seek(block.Offset, 0); err != nil {
+		return nil, err
+	}
+
+	gzReader, err := gzip.NewReader(segment)
+	if err != nil {
+		return nil, err
+	}
+	gzReader.Multistream(false)
+	defer gzReader.Close()
+
+	entries := make([]client.LeafEntry, block.Count)
+	for i := range entries {
+		if entries[i].LeafInput, err = readRecord(gzReader); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %s", block.segment, block.Start+uint64(i), err)
+		}
+		if entries[i].ExtraData, err = readRecord(gzReader); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %s", block.segment, block.Start+uint64(i), err)
+		}
+	}
+	return entries, nil
+}
+
+func writeRecord(w io.Writer, data []byte) {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(data)))
+	w.Write(length)
+	w.Write(data)
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(length))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -15,6 +15,9 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
 )
 
 type CensysEntry struct {
@@ -154,10 +157,14 @@ type HttpImporter struct {
 	decoder        *json.Decoder
 	url            string
 	resp           *http.Response
+	ctx            context.Context
 }
 
-func OpenURL(url string) (*HttpImporter, error) {
+// OpenURL prepares to stream the dump at url; the download is abandoned when
+// ctx is done.
+func OpenURL(ctx context.Context, url string) (*HttpImporter, error) {
 	importer := &HttpImporter{
+		ctx:         ctx,
 		currentLine: 0,
 		byteCounter: &ImporterByteCounter{},
 		url:         url,
@@ -222,7 +229,7 @@ func (imp *HttpImporter) NextEntry() (*CensysEntry, error) {
 		}
 
 		client := &http.Client{}
-		imp.resp, err = client.Do(req)
+		imp.resp, err = ctxhttp.Do(imp.ctx, client, req)
 		if err != nil {
 			return nil, err
 		}
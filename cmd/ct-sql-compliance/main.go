@@ -0,0 +1,80 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+var (
+	config = utils.NewCTConfig()
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	dbConnectStr, err := sqldb.RecombineURLForDB(*config.DbConnect)
+	if err != nil {
+		log.Printf("unable to parse %s: %s", *config.DbConnect, err)
+	}
+
+	if len(dbConnectStr) == 0 {
+		config.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("mysql", dbConnectStr)
+	if err != nil {
+		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("unable to ping SQL: %s: %s", dbConnectStr, err)
+	}
+
+	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
+	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
+	entriesDb := &sqldb.EntriesDatabase{
+		DbMap:        dbMap,
+		SQLDebug:     *config.SQLDebug,
+		Verbose:      *config.Verbose,
+		KnownIssuers: make(map[string]int),
+	}
+	err = entriesDb.InitTables()
+	if err != nil {
+		log.Fatalf("unable to prepare SQL DB. dbConnectStr=%s: %s", dbConnectStr, err)
+	}
+
+	policy, err := sqldb.ParseCTPolicy(*config.CTPolicy, *config.CTPolicyOperators)
+	if err != nil {
+		log.Fatalf("unable to parse ctPolicy: %s", err)
+	}
+
+	count, unknown, err := entriesDb.EvaluateCompliance(policy)
+	if err != nil {
+		log.Fatalf("error while evaluating compliance: %s", err)
+	}
+	log.Printf("Evaluated %d unexpired certificates, %d more unknown", count, unknown)
+
+	issuers, err := entriesDb.GetIssuerCompliance()
+	if err != nil {
+		log.Fatalf("unable to count compliance by issuer: %s", err)
+	}
+	for _, issuer := range issuers {
+		if issuer.NonCompliant == 0 && !*config.Verbose {
+			continue
+		}
+		log.Printf("%6d of %6d non-compliant, %6d unknown: %s (issuerID %d)",
+			issuer.NonCompliant, issuer.Certificates, issuer.Unknown, issuer.CommonName, issuer.IssuerID)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+var (
+	config = utils.NewCTConfig()
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	dbConnectStr, err := sqldb.RecombineURLForDB(*config.DbConnect)
+	if err != nil {
+		log.Printf("unable to parse %s: %s", *config.DbConnect, err)
+	}
+
+	if len(dbConnectStr) == 0 {
+		config.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("mysql", dbConnectStr)
+	if err != nil {
+		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("unable to ping SQL: %s: %s", dbConnectStr, err)
+	}
+
+	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
+	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
+	entriesDb := &sqldb.EntriesDatabase{
+		DbMap:        dbMap,
+		SQLDebug:     *config.SQLDebug,
+		Verbose:      *config.Verbose,
+		KnownIssuers: make(map[string]int),
+	}
+	err = entriesDb.InitTables()
+	if err != nil {
+		log.Fatalf("unable to prepare SQL DB. dbConnectStr=%s: %s", dbConnectStr, err)
+	}
+
+	var since time.Time
+	if config.EarliestDateFilter != nil && len(*config.EarliestDateFilter) > 1 {
+		since, err = time.Parse("2006-01-02", *config.EarliestDateFilter)
+		if err != nil {
+			log.Fatalf("unable to parse EarliestDateFilter: %s: %s", *config.EarliestDateFilter, err)
+		}
+	}
+	until := time.Now()
+	if config.LatestDateFilter != nil && len(*config.LatestDateFilter) > 1 {
+		until, err = time.Parse("2006-01-02", *config.LatestDateFilter)
+		if err != nil {
+			log.Fatalf("unable to parse LatestDateFilter: %s: %s", *config.LatestDateFilter, err)
+		}
+	}
+
+	report, err := entriesDb.GetCrossLogReport(since, until, *config.ReportPeriod)
+	if err != nil {
+		log.Fatalf("unable to build cross-log report: %s", err)
+	}
+
+	switch *config.OutputFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		err = encoder.Encode(report)
+	case "csv":
+		err = writeCSV(csv.NewWriter(os.Stdout), report)
+	default:
+		log.Fatalf("unknown output format: %s", *config.OutputFormat)
+	}
+	if err != nil {
+		log.Fatalf("unable to write report: %s", err)
+	}
+	os.Exit(0)
+}
+
+// writeCSV writes each part of the report as its own table, the overlaps as
+// a matrix of log IDs.
+func writeCSV(w *csv.Writer, report *sqldb.CrossLogReport) error {
+	w.Write([]string{"# certificates by " + report.Period + ", issuer and number of logs"})
+	w.Write([]string{report.Period, "issuerID", "commonName", "certificates", "oneLog", "twoLogs", "threeOrMore"})
+	for _, s := range report.Spread {
+		w.Write([]string{s.Period, strconv.Itoa(s.IssuerID), s.CommonName, strconv.Itoa(s.Certificates),
+			strconv.Itoa(s.OneLog), strconv.Itoa(s.TwoLogs), strconv.Itoa(s.ThreeOrMore)})
+	}
+
+	w.Write([]string{})
+	w.Write([]string{"# certificates held by only one log"})
+	w.Write([]string{"logID", "url", "certificates"})
+	for _, u := range report.Unique {
+		w.Write([]string{strconv.Itoa(u.LogID), u.URL, strconv.Itoa(u.Certificates)})
+	}
+
+	var logIDs []int
+	overlap := make(map[[2]int]int)
+	for _, o := range report.Overlaps {
+		if o.LogA == o.LogB {
+			logIDs = append(logIDs, o.LogA)
+		}
+		overlap[[2]int{o.LogA, o.LogB}] = o.Certificates
+		overlap[[2]int{o.LogB, o.LogA}] = o.Certificates
+	}
+	sort.Ints(logIDs)
+
+	w.Write([]string{})
+	w.Write([]string{"# certificates held by both logs"})
+	header := []string{"logID"}
+	for _, logID := range logIDs {
+		header = append(header, strconv.Itoa(logID))
+	}
+	w.Write(header)
+	for _, a := range logIDs {
+		row := []string{strconv.Itoa(a)}
+		for _, b := range logIDs {
+			row = append(row, strconv.Itoa(overlap[[2]int{a, b}]))
+		}
+		w.Write(row)
+	}
+
+	w.Flush()
+	return w.Error()
+}
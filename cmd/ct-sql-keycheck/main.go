@@ -0,0 +1,93 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+var (
+	config = utils.NewCTConfig()
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	dbConnectStr, err := sqldb.RecombineURLForDB(*config.DbConnect)
+	if err != nil {
+		log.Printf("unable to parse %s: %s", *config.DbConnect, err)
+	}
+
+	if len(dbConnectStr) == 0 || len(*config.CertPath) == 0 {
+		// The keys are read from the full certificates
+		config.Usage()
+		os.Exit(2)
+	}
+
+	var blocklists []string
+	for _, part := range strings.Split(*config.DebianWeakKeys, ",") {
+		if path := strings.TrimSpace(part); len(path) > 0 {
+			blocklists = append(blocklists, path)
+		}
+	}
+	debianKeys, err := utils.LoadDebianWeakKeys(blocklists)
+	if err != nil {
+		log.Fatalf("unable to load Debian weak keys: %s", err)
+	}
+
+	db, err := sql.Open("mysql", dbConnectStr)
+	if err != nil {
+		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("unable to ping SQL: %s: %s", dbConnectStr, err)
+	}
+
+	certFolderDB, err := utils.NewFolderDatabase(*config.CertPath, 0444, *config.CertsPerFolder)
+	if err != nil {
+		log.Fatalf("unable to open Certificate Path: %s: %s", *config.CertPath, err)
+	}
+
+	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
+	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
+	entriesDb := &sqldb.EntriesDatabase{
+		DbMap:        dbMap,
+		SQLDebug:     *config.SQLDebug,
+		Verbose:      *config.Verbose,
+		FullCerts:    certFolderDB,
+		KnownIssuers: make(map[string]int),
+	}
+	err = entriesDb.InitTables()
+	if err != nil {
+		log.Fatalf("unable to prepare SQL DB. dbConnectStr=%s: %s", dbConnectStr, err)
+	}
+
+	report, err := entriesDb.CheckKeys(debianKeys, *config.BatchGCDSize)
+	if err != nil {
+		log.Fatalf("unable to check keys: %s", err)
+	}
+
+	log.Printf("Checked %d RSA keys, %d distinct moduli, against %d Debian weak keys",
+		report.Checked, report.Moduli, len(debianKeys))
+	var kinds []string
+	for kind := range report.Findings {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		log.Printf("%s: %d certificates", kind, report.Findings[kind])
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,87 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+var (
+	config = utils.NewCTConfig()
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	dbConnectStr, err := sqldb.RecombineURLForDB(*config.DbConnect)
+	if err != nil {
+		log.Printf("unable to parse %s: %s", *config.DbConnect, err)
+	}
+
+	if len(dbConnectStr) == 0 {
+		config.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("mysql", dbConnectStr)
+	if err != nil {
+		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("unable to ping SQL: %s: %s", dbConnectStr, err)
+	}
+
+	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
+	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
+	entriesDb := &sqldb.EntriesDatabase{
+		DbMap:        dbMap,
+		SQLDebug:     *config.SQLDebug,
+		Verbose:      *config.Verbose,
+		KnownIssuers: make(map[string]int),
+	}
+	err = entriesDb.InitTables()
+	if err != nil {
+		log.Fatalf("unable to prepare SQL DB. dbConnectStr=%s: %s", dbConnectStr, err)
+	}
+
+	reuse, err := entriesDb.GetKeyReuse(*config.KeyReuseCerts, *config.KeyReuseDomains)
+	if err != nil {
+		log.Fatalf("unable to find reused keys: %s", err)
+	}
+
+	switch *config.OutputFormat {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		err = encoder.Encode(reuse)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"spkiHash", "certificates", "registeredDomains", "issuers", "firstSeen", "lastSeen"})
+		for _, key := range reuse {
+			w.Write([]string{hex.EncodeToString(key.SPKIHash), strconv.Itoa(key.Certificates),
+				strconv.Itoa(key.RegisteredDomains), strconv.Itoa(key.Issuers),
+				key.FirstSeen.Format("2006-01-02"), key.LastSeen.Format("2006-01-02")})
+		}
+		w.Flush()
+		err = w.Error()
+	default:
+		log.Fatalf("unknown output format: %s", *config.OutputFormat)
+	}
+	if err != nil {
+		log.Fatalf("unable to write report: %s", err)
+	}
+	os.Exit(0)
+}
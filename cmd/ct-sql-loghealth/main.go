@@ -0,0 +1,139 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+var (
+	config = utils.NewCTConfig()
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	dbConnectStr, err := sqldb.RecombineURLForDB(*config.DbConnect)
+	if err != nil {
+		log.Printf("unable to parse %s: %s", *config.DbConnect, err)
+	}
+
+	if len(dbConnectStr) == 0 {
+		config.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("mysql", dbConnectStr)
+	if err != nil {
+		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("unable to ping SQL: %s: %s", dbConnectStr, err)
+	}
+
+	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
+	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
+	entriesDb := &sqldb.EntriesDatabase{
+		DbMap:        dbMap,
+		SQLDebug:     *config.SQLDebug,
+		Verbose:      *config.Verbose,
+		KnownIssuers: make(map[string]int),
+	}
+	err = entriesDb.InitTables()
+	if err != nil {
+		log.Fatalf("unable to prepare SQL DB. dbConnectStr=%s: %s", dbConnectStr, err)
+	}
+
+	now := time.Now()
+	window := time.Duration(*config.HealthWindow) * time.Hour
+	summaries, err := entriesDb.GetLogHealthSince(now.Add(-window))
+	if err != nil {
+		log.Fatalf("unable to read log health: %s", err)
+	}
+
+	type logTotals struct {
+		calls, errors int
+		p95Ms, maxMs  int64
+		lastError     string
+		sthTime       time.Time
+	}
+	totals := make(map[int]*logTotals)
+	for _, summary := range summaries {
+		t, ok := totals[summary.LogID]
+		if !ok {
+			t = &logTotals{}
+			totals[summary.LogID] = t
+		}
+		t.calls += summary.Calls
+		t.errors += summary.Errors
+		if summary.P95Ms > t.p95Ms {
+			t.p95Ms = summary.P95Ms
+		}
+		if summary.MaxMs > t.maxMs {
+			t.maxMs = summary.MaxMs
+		}
+		if len(summary.LastError) > 0 {
+			t.lastError = summary.LastError
+		}
+		if summary.STHTime.After(t.sthTime) {
+			t.sthTime = summary.STHTime
+		}
+	}
+
+	logObjs, err := entriesDb.GetLogs()
+	if err != nil {
+		log.Fatalf("unable to list logs: %s", err)
+	}
+
+	unhealthy := false
+	for i := range logObjs {
+		logObj := &logObjs[i]
+		t, ok := totals[logObj.LogID]
+		if !ok {
+			if *config.Verbose {
+				log.Printf("[%s] No calls recorded in the last %s", logObj.URL, window)
+			}
+			continue
+		}
+
+		var problems []string
+		if t.calls > 0 && float64(t.errors)/float64(t.calls) > *config.MaxErrorRate {
+			problems = append(problems, fmt.Sprintf("%d of %d calls failed, last: %s", t.errors, t.calls, t.lastError))
+		}
+		if t.sthTime.IsZero() {
+			problems = append(problems, "no tree head fetched")
+		} else if age := now.Sub(t.sthTime); age > logObj.MaxMergeDelay() {
+			problems = append(problems, fmt.Sprintf("tree head is %s old, beyond its MMD of %s", age, logObj.MaxMergeDelay()))
+		}
+
+		if len(problems) == 0 {
+			if *config.Verbose {
+				log.Printf("[%s] Healthy: %d calls, %d errors, p95 %dms, max %dms",
+					logObj.URL, t.calls, t.errors, t.p95Ms, t.maxMs)
+			}
+			continue
+		}
+
+		unhealthy = true
+		log.Printf("[%s] Unhealthy: %s (p95 %dms, max %dms)",
+			logObj.URL, strings.Join(problems, "; "), t.p95Ms, t.maxMs)
+	}
+
+	if unhealthy {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
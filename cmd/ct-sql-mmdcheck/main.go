@@ -0,0 +1,92 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+var (
+	config = utils.NewCTConfig()
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	dbConnectStr, err := sqldb.RecombineURLForDB(*config.DbConnect)
+	if err != nil {
+		log.Printf("unable to parse %s: %s", *config.DbConnect, err)
+	}
+
+	if len(dbConnectStr) == 0 {
+		config.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("mysql", dbConnectStr)
+	if err != nil {
+		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("unable to ping SQL: %s: %s", dbConnectStr, err)
+	}
+
+	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
+	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
+	entriesDb := &sqldb.EntriesDatabase{
+		DbMap:        dbMap,
+		SQLDebug:     *config.SQLDebug,
+		Verbose:      *config.Verbose,
+		KnownIssuers: make(map[string]int),
+	}
+	err = entriesDb.InitTables()
+	if err != nil {
+		log.Fatalf("unable to prepare SQL DB. dbConnectStr=%s: %s", dbConnectStr, err)
+	}
+
+	logObjs, err := entriesDb.GetLogs()
+	if err != nil {
+		log.Fatalf("unable to list logs: %s", err)
+	}
+
+	failed := false
+	for i := range logObjs {
+		logObj := &logObjs[i]
+		if len(logObj.KeyID) == 0 {
+			// SCTs can't be attributed to a log without its key
+			if *config.Verbose {
+				log.Printf("[%s] Skipping, no public key known", logObj.URL)
+			}
+			continue
+		}
+
+		gaps, err := entriesDb.FindGaps(logObj.LogID, logObj.MaxEntry)
+		if err == nil && len(gaps) > 0 {
+			log.Printf("[%s] Warning: %d gaps in coverage; missing entries may be false positives", logObj.URL, len(gaps))
+		}
+
+		report, err := entriesDb.CheckMMD(logObj)
+		if err != nil {
+			log.Printf("[%s] Unable to check MMD: %s", logObj.URL, err)
+			failed = true
+			continue
+		}
+		log.Printf("[%s] MMD %s: %d SCTs with entries, %d incorporated late, %d missing",
+			logObj.URL, report.MMD, report.Checked, report.Late, report.Missing)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
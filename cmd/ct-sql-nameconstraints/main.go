@@ -0,0 +1,79 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+var (
+	config = utils.NewCTConfig()
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	dbConnectStr, err := sqldb.RecombineURLForDB(*config.DbConnect)
+	if err != nil {
+		log.Printf("unable to parse %s: %s", *config.DbConnect, err)
+	}
+
+	if len(dbConnectStr) == 0 {
+		config.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("mysql", dbConnectStr)
+	if err != nil {
+		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("unable to ping SQL: %s: %s", dbConnectStr, err)
+	}
+
+	certFolderDB, err := utils.NewFolderDatabase(*config.CertPath, 0444, *config.CertsPerFolder)
+	if err != nil {
+		log.Fatalf("unable to open Certificate Path: %s: %s", *config.CertPath, err)
+	}
+
+	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
+	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
+	entriesDb := &sqldb.EntriesDatabase{
+		DbMap:        dbMap,
+		SQLDebug:     *config.SQLDebug,
+		Verbose:      *config.Verbose,
+		FullCerts:    certFolderDB,
+		KnownIssuers: make(map[string]int),
+	}
+	err = entriesDb.InitTables()
+	if err != nil {
+		log.Fatalf("unable to prepare SQL DB. dbConnectStr=%s: %s", dbConnectStr, err)
+	}
+
+	checked, violations, err := entriesDb.CheckNameConstraints()
+	if err != nil {
+		log.Fatalf("error while checking name constraints: %s", err)
+	}
+	log.Printf("Checked %d names of certificates from name-constrained issuers", checked)
+
+	for _, violation := range violations {
+		kind := "not permitted by"
+		if violation.Excluded {
+			kind = "excluded by"
+		}
+		log.Printf("certID %d (issuerID %d): %s %s %s of CA key %s",
+			violation.CertID, violation.IssuerID, violation.Name, kind, violation.Constraint, violation.KeyID)
+	}
+	log.Printf("Found %d names violating name constraints", len(violations))
+	os.Exit(0)
+}
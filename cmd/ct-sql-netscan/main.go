@@ -6,15 +6,12 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
 	"log"
 	"net"
 	"os"
-	"os/signal"
 	"runtime"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -23,6 +20,7 @@ import (
 	"github.com/jcjones/ct-sql/sqldb"
 	"github.com/jcjones/ct-sql/utils"
 	"github.com/oschwald/geoip2-golang"
+	"golang.org/x/net/context"
 )
 
 type ResolutionEntry struct {
@@ -103,9 +101,10 @@ func main() {
 		log.Fatalf("unable to execute SQL: %s", err)
 	}
 
-	err = netscan.processEntries(entries)
+	ctx := utils.SignalContext(time.Duration(*config.ShutdownTimeout) * time.Second)
+	err = netscan.processEntries(ctx, entries)
 
-	if err != nil {
+	if err != nil && err != context.Canceled {
 		log.Fatalf("error while running importer: %s", err)
 	}
 
@@ -168,7 +167,9 @@ func (ns *NetScan) resolveWorker(entries <-chan ResolutionEntry) {
 	}
 }
 
-func (ns *NetScan) processEntries(entries []ResolutionEntry) error {
+// processEntries hands the entries to resolve workers, stopping early if ctx
+// is done; the workers finish what they were given.
+func (ns *NetScan) processEntries(ctx context.Context, entries []ResolutionEntry) error {
 	entryChan := make(chan ResolutionEntry, 10)
 	defer close(entryChan)
 	ns.wg.Add(1)
@@ -176,11 +177,6 @@ func (ns *NetScan) processEntries(entries []ResolutionEntry) error {
 	progressDisplay := utils.NewProgressDisplay()
 	defer progressDisplay.Close()
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
-	defer signal.Stop(sigChan)
-	defer close(sigChan)
-
 	progressDisplay.StartDisplay(ns.wg)
 
 	numWorkers := *config.NumThreads * runtime.NumCPU()
@@ -194,8 +190,9 @@ func (ns *NetScan) processEntries(entries []ResolutionEntry) error {
 			if i%256 == 0 {
 				progressDisplay.UpdateProgress("Scanner", 0, uint64(i), uint64(len(entries)))
 			}
-		case sig := <-sigChan:
-			return fmt.Errorf("Signal caught: %s", sig)
+		case <-ctx.Done():
+			log.Printf("Stopping after %d of %d names", i, len(entries))
+			return ctx.Err()
 		}
 	}
 
@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/go-gorp/gorp"
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/jsonclient"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+var (
+	config = utils.NewCTConfig()
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	dbConnectStr, err := sqldb.RecombineURLForDB(*config.DbConnect)
+	if err != nil {
+		log.Printf("unable to parse %s: %s", *config.DbConnect, err)
+	}
+
+	if len(dbConnectStr) == 0 {
+		config.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("mysql", dbConnectStr)
+	if err != nil {
+		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("unable to ping SQL: %s: %s", dbConnectStr, err)
+	}
+
+	// Roots are stored as certificates, so keep their DER alongside the others
+	var certFolderDB *utils.FolderDatabase
+	if config.CertPath != nil && len(*config.CertPath) > 0 {
+		certFolderDB, err = utils.NewFolderDatabase(*config.CertPath, 0444, *config.CertsPerFolder)
+		if err != nil {
+			log.Fatalf("unable to open Certificate Path: %s: %s", *config.CertPath, err)
+		}
+	}
+
+	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
+	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
+	entriesDb := &sqldb.EntriesDatabase{
+		DbMap:        dbMap,
+		SQLDebug:     *config.SQLDebug,
+		Verbose:      *config.Verbose,
+		FullCerts:    certFolderDB,
+		KnownIssuers: make(map[string]int),
+	}
+	err = entriesDb.InitTables()
+	if err != nil {
+		log.Fatalf("unable to prepare SQL DB. dbConnectStr=%s: %s", dbConnectStr, err)
+	}
+
+	// Logs given on the command line, or else every log we know
+	var logObjs []*sqldb.CertificateLog
+	var logUrls []string
+	if config.LogUrl != nil && len(*config.LogUrl) > 5 {
+		logUrls = append(logUrls, *config.LogUrl)
+	}
+	if config.LogUrlList != nil && len(*config.LogUrlList) > 5 {
+		for _, part := range strings.Split(*config.LogUrlList, ",") {
+			logUrls = append(logUrls, strings.TrimSpace(part))
+		}
+	}
+	for _, logUrl := range logUrls {
+		urlParts, err := url.Parse(logUrl)
+		if err != nil {
+			log.Fatalf("unable to set Certificate Log: %s", err)
+		}
+		logObj, err := entriesDb.GetLogState(fmt.Sprintf("%s%s", urlParts.Host, urlParts.Path))
+		if err != nil {
+			log.Fatalf("unable to set Certificate Log: %s", err)
+		}
+		logObjs = append(logObjs, logObj)
+	}
+	if len(logUrls) == 0 {
+		knownLogs, err := entriesDb.GetLogs()
+		if err != nil {
+			log.Fatalf("unable to list logs: %s", err)
+		}
+		for i := range knownLogs {
+			logObjs = append(logObjs, &knownLogs[i])
+		}
+	}
+
+	ctx := utils.SignalContext(time.Duration(*config.ShutdownTimeout) * time.Second)
+
+	failed := false
+	for _, logObj := range logObjs {
+		if ctx.Err() != nil {
+			failed = true
+			break
+		}
+
+		// Logs are stored without their scheme
+		ctLog, err := client.New(fmt.Sprintf("https://%s", logObj.URL), nil, jsonclient.Options{})
+		if err != nil {
+			log.Printf("[%s] Unable to construct CT log client: %s", logObj.URL, err)
+			failed = true
+			continue
+		}
+
+		roots, err := ctLog.GetAcceptedRoots(ctx)
+		if err != nil {
+			log.Printf("[%s] Unable to fetch accepted roots: %s", logObj.URL, err)
+			failed = true
+			continue
+		}
+
+		added, removed, err := entriesDb.UpdateAcceptedRoots(ctx, logObj.LogID, roots)
+		if err != nil {
+			log.Printf("[%s] Unable to store accepted roots: %s", logObj.URL, err)
+			failed = true
+			continue
+		}
+		log.Printf("[%s] Accepts %d roots: %d added, %d removed", logObj.URL, len(roots), added, removed)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,194 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/go-gorp/gorp"
+	"github.com/google/certificate-transparency/go"
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/jsonclient"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/submitter"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+var (
+	config = utils.NewCTConfig()
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	dbConnectStr, err := sqldb.RecombineURLForDB(*config.DbConnect)
+	if err != nil {
+		log.Printf("unable to parse %s: %s", *config.DbConnect, err)
+	}
+
+	if len(dbConnectStr) == 0 || len(*config.CertPath) == 0 || len(*config.LogUrl) == 0 {
+		// Submissions need the full certificates, and a log to send them to
+		config.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("mysql", dbConnectStr)
+	if err != nil {
+		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatalf("unable to ping SQL: %s: %s", dbConnectStr, err)
+	}
+
+	certFolderDB, err := utils.NewFolderDatabase(*config.CertPath, 0444, *config.CertsPerFolder)
+	if err != nil {
+		log.Fatalf("unable to open Certificate Path: %s: %s", *config.CertPath, err)
+	}
+
+	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
+	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
+	entriesDb := &sqldb.EntriesDatabase{
+		DbMap:        dbMap,
+		SQLDebug:     *config.SQLDebug,
+		Verbose:      *config.Verbose,
+		FullCerts:    certFolderDB,
+		KnownIssuers: make(map[string]int),
+	}
+	err = entriesDb.InitTables()
+	if err != nil {
+		log.Fatalf("unable to prepare SQL DB. dbConnectStr=%s: %s", dbConnectStr, err)
+	}
+
+	urlParts, err := url.Parse(*config.LogUrl)
+	if err != nil {
+		log.Fatalf("unable to set Certificate Log: %s", err)
+	}
+	logObj, err := entriesDb.GetLogState(fmt.Sprintf("%s%s", urlParts.Host, urlParts.Path))
+	if err != nil {
+		log.Fatalf("unable to set Certificate Log: %s", err)
+	}
+	if len(*config.LogKey) > 0 {
+		keyPEM, err := ioutil.ReadFile(*config.LogKey)
+		if err != nil {
+			log.Fatalf("unable to read log key: %s", err)
+		}
+		if err = entriesDb.SetLogKey(logObj, keyPEM); err != nil {
+			log.Fatalf("unable to set log key: %s", err)
+		}
+	}
+	if len(logObj.PublicKey) == 0 {
+		log.Fatalf("[%s] No public key known to verify SCTs; set logKey", *config.LogUrl)
+	}
+
+	pubKey, err := x509.ParsePKIXPublicKey(logObj.PublicKey)
+	if err != nil {
+		log.Fatalf("unable to parse log key: %s", err)
+	}
+	verifier, err := ct.NewSignatureVerifier(pubKey)
+	if err != nil {
+		log.Fatalf("unable to use log key: %s", err)
+	}
+	ctLog, err := client.New(*config.LogUrl, nil, jsonclient.Options{})
+	if err != nil {
+		log.Fatalf("unable to construct CT log client: %s", err)
+	}
+	logSubmitter := submitter.New(ctLog, verifier)
+
+	// Certificate IDs given as arguments, or else those the log is missing
+	var certIDs []uint64
+	for _, arg := range flag.Args() {
+		certID, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			log.Fatalf("unable to parse as integer: %s", err)
+		}
+		certIDs = append(certIDs, certID)
+	}
+	if len(certIDs) == 0 {
+		certIDs, err = entriesDb.GetSubmissionCandidates(logObj.LogID, *config.Limit)
+		if err != nil {
+			log.Fatalf("unable to find certificates to submit: %s", err)
+		}
+	}
+
+	ctx := utils.SignalContext(time.Duration(*config.ShutdownTimeout) * time.Second)
+
+	outcomes := make(map[string]int)
+	var skipped int
+	for i, certID := range certIDs {
+		if ctx.Err() != nil {
+			// Nothing was submitted for the rest
+			skipped += len(certIDs) - i
+			break
+		}
+
+		der, chain, err := entriesDb.GetStoredCertificate(certID)
+		if err != nil {
+			// Only certificates from logs have stored chains
+			if *config.Verbose {
+				log.Printf("Skipping certId %d: %s", certID, err)
+			}
+			skipped++
+			continue
+		}
+
+		submitChain := chain.Chain
+		if chain.EntryType == ct.X509LogEntryType {
+			submitChain = append([]ct.ASN1Cert{der}, chain.Chain...)
+		}
+
+		obj := &sqldb.Submission{
+			CertID:      certID,
+			LogID:       logObj.LogID,
+			SubmittedAt: time.Now(),
+			Outcome:     sqldb.SubmissionAccepted,
+		}
+
+		sct, err := logSubmitter.Submit(ctx, chain.EntryType, submitChain)
+		if ctx.Err() != nil {
+			// Interrupted, so the log's answer is unknown
+			skipped += len(certIDs) - i
+			break
+		}
+		switch err.(type) {
+		case nil:
+		case submitter.BadSCTError:
+			obj.Outcome = sqldb.SubmissionBadSCT
+		case submitter.UnverifiableError:
+			obj.Outcome = sqldb.SubmissionUnverifiable
+		default:
+			obj.Outcome = sqldb.SubmissionRejected
+		}
+		if err != nil {
+			obj.Error = err.Error()
+			log.Printf("[%s] certId %d: %s", *config.LogUrl, certID, err)
+		}
+		if sct != nil {
+			obj.SCTTime = utils.Uint64ToTimestamp(sct.Timestamp)
+			obj.Signature, _ = ct.MarshalDigitallySigned(sct.Signature)
+		}
+
+		if err = entriesDb.RecordSubmission(obj); err != nil {
+			log.Fatalf("unable to record submission of certId %d: %s", certID, err)
+		}
+		outcomes[obj.Outcome]++
+	}
+
+	log.Printf("[%s] Submitted %d certificates: %d accepted, %d bad SCTs, %d unverifiable, %d rejected; %d skipped",
+		*config.LogUrl, len(certIDs)-skipped, outcomes[sqldb.SubmissionAccepted],
+		outcomes[sqldb.SubmissionBadSCT], outcomes[sqldb.SubmissionUnverifiable],
+		outcomes[sqldb.SubmissionRejected], skipped)
+	os.Exit(0)
+}
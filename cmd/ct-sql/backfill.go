@@ -0,0 +1,74 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+
+	"golang.org/x/net/context"
+)
+
+// Backfill finds the index ranges below the log's MaxEntry that were never
+// examined, such as those skipped by an -offset run, and downloads just those.
+func (ld *LogDownloader) Backfill(ctx context.Context, ctLogUrl string, source LogSource) {
+	urlParts, err := url.Parse(ctLogUrl)
+	if err != nil {
+		log.Printf("[%s] Unable to parse Certificate Log: %s", ctLogUrl, err)
+		return
+	}
+	logObj, err := ld.Database.GetLogState(fmt.Sprintf("%s%s", urlParts.Host, urlParts.Path))
+	if err != nil {
+		log.Printf("[%s] Unable to set Certificate Log: %s", ctLogUrl, err)
+		return
+	}
+
+	log.Printf("[%s] Scanning for gaps below %d... ", ctLogUrl, logObj.MaxEntry)
+	gaps, err := ld.Database.FindGaps(logObj.LogID, logObj.MaxEntry)
+	if err != nil {
+		log.Printf("[%s] Unable to find gaps: %s", ctLogUrl, err)
+		return
+	}
+
+	var missing uint64
+	for _, gap := range gaps {
+		missing += gap.Len()
+		if *config.Verbose {
+			log.Printf("[%s] Missing %d to %d", ctLogUrl, gap.Start, gap.End)
+		}
+	}
+	log.Printf("[%s] Found %d gaps, %d entries missing", ctLogUrl, len(gaps), missing)
+
+	remaining := *config.Limit
+	for _, gap := range gaps {
+		end := gap.End
+		if *config.Limit > 0 {
+			if remaining == 0 {
+				break
+			}
+			if gap.Len() > remaining {
+				end = gap.Start + remaining
+			}
+			remaining -= end - gap.Start
+		}
+
+		log.Printf("[%s] Backfilling from %d to %d\n", ctLogUrl, gap.Start, end)
+
+		watermark := NewWatermark(gap.Start)
+		finalIndex, err := ld.DownloadCTRangeToChannel(ctx, logObj, watermark, source, gap.Start, end, func() error {
+			return ld.checkpoint(logObj, watermark)
+		})
+		watermark.WaitFor(finalIndex)
+
+		if cpErr := ld.checkpoint(logObj, watermark); cpErr != nil {
+			log.Printf("[%s] Unable to save coverage: %s", ctLogUrl, cpErr)
+		}
+		if err != nil {
+			log.Printf("\n[%s] Backfill halting, error caught: %s\n", ctLogUrl, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+
+	"github.com/jcjones/ct-sql/sqldb"
+	"golang.org/x/net/context"
+)
+
+// backfillStored runs fill over the certificates get returns, up to limit, then
+// records the last one tried, so the next run of the kind carries on from
+// there.
+func backfillStored(ctx context.Context, db *sqldb.EntriesDatabase, kind, what string,
+	get func(uint64) ([]uint64, error), fill func(uint64) (bool, error)) error {
+	certIDs, err := get(*config.Limit)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Backfilling %s of %d certificates", what, len(certIDs))
+
+	var filled int
+	var lastCertID uint64
+	for i, certID := range certIDs {
+		if ctx.Err() != nil {
+			log.Printf("Stopping after %d certificates", i)
+			break
+		}
+
+		var ok bool
+		ok, err = fill(certID)
+		if err != nil {
+			break
+		}
+		if ok {
+			filled++
+		}
+		lastCertID = certID
+	}
+
+	log.Printf("Backfilled %s of %d of %d certificates", what, filled, len(certIDs))
+	if lastCertID > 0 {
+		if saveErr := db.SaveBackfillProgress(kind, lastCertID); saveErr != nil {
+			return saveErr
+		}
+	}
+	return err
+}
+
+// backfillSPKI indexes the keys of the certificates inserted before keys were
+// indexed, from the stored certificates.
+func backfillSPKI(ctx context.Context, db *sqldb.EntriesDatabase) error {
+	return backfillStored(ctx, db, sqldb.BackfillSPKI, "key hashes", db.GetCertsWithoutSPKIHash, db.BackfillSPKIHash)
+}
+
+// backfillFingerprints records the fingerprints of the certificates inserted
+// before they were kept, from the stored certificates, so knownCertFilter
+// recognizes them.
+func backfillFingerprints(ctx context.Context, db *sqldb.EntriesDatabase) error {
+	return backfillStored(ctx, db, sqldb.BackfillFingerprints, "fingerprints", db.GetCertsWithoutFingerprint, db.BackfillFingerprint)
+}
@@ -0,0 +1,286 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/certificate-transparency/go"
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/google/certificate-transparency/go/jsonclient"
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/tiledlog"
+	"github.com/jcjones/ct-sql/utils"
+	"github.com/jpillora/backoff"
+	"golang.org/x/net/context"
+)
+
+// fetchError describes a failed request to a CT log, keeping the HTTP status
+// (zero if the request never got a response) and any Retry-After the log sent.
+type fetchError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *fetchError) Error() string {
+	return e.Err.Error()
+}
+
+// Transient reports whether the request is worth retrying: network failures,
+// throttling and server-side errors.
+func (e *fetchError) Transient() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Throttled reports whether the log explicitly asked us to slow down.
+func (e *fetchError) Throttled() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+}
+
+// Retry-After may be either a number of seconds or an HTTP date (RFC 7231
+// Section 7.1.3)
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(time.Now()); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// LogSource is anywhere log entries can be downloaded from.
+type LogSource interface {
+	// GetTreeHead returns the verified tree size and timestamp of the log.
+	GetTreeHead(ctx context.Context) (uint64, uint64, error)
+	// GetRawEntries returns entries [start, end] in get-entries form, or a
+	// *fetchError if the request failed.
+	GetRawEntries(ctx context.Context, start, end uint64) ([]client.LeafEntry, error)
+}
+
+// rfc6962Source reads a log through the RFC 6962 JSON API.
+type rfc6962Source struct {
+	ctLog *client.LogClient
+}
+
+func (src *rfc6962Source) GetTreeHead(ctx context.Context) (uint64, uint64, error) {
+	sth, err := src.ctLog.GetSTH(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return sth.TreeSize, sth.Timestamp, nil
+}
+
+func (src *rfc6962Source) GetRawEntries(ctx context.Context, start, end uint64) ([]client.LeafEntry, error) {
+	return fetchRawEntries(ctx, src.ctLog, start, end)
+}
+
+// tiledSource reads a log through the Static CT API, only reading up to the
+// most recently verified checkpoint.
+type tiledSource struct {
+	tiledLog *tiledlog.TiledLog
+	treeSize uint64
+}
+
+func (src *tiledSource) GetTreeHead(ctx context.Context) (uint64, uint64, error) {
+	checkpoint, err := src.tiledLog.GetCheckpoint(ctx)
+	if err != nil {
+		return 0, 0, tiledFetchError(err)
+	}
+	src.treeSize = checkpoint.TreeSize
+	return checkpoint.TreeSize, checkpoint.Timestamp, nil
+}
+
+func (src *tiledSource) GetRawEntries(ctx context.Context, start, end uint64) ([]client.LeafEntry, error) {
+	if src.treeSize == 0 {
+		if _, _, err := src.GetTreeHead(ctx); err != nil {
+			return nil, err
+		}
+	}
+	entries, err := src.tiledLog.GetRawEntries(ctx, src.treeSize, start, end)
+	if err != nil {
+		return nil, tiledFetchError(err)
+	}
+	return entries, nil
+}
+
+// tiledFetchError converts HTTP and network failures from the tiled log into
+// a *fetchError, so that they're retried the same way.
+func tiledFetchError(err error) error {
+	switch e := err.(type) {
+	case *tiledlog.HTTPError:
+		return &fetchError{
+			StatusCode: e.StatusCode,
+			RetryAfter: parseRetryAfter(e.RetryAfter),
+			Err:        err,
+		}
+	case *url.Error:
+		return &fetchError{Err: err}
+	}
+	return err
+}
+
+// openLogSource connects to the log at ctLogUrl. Tiled logs need the log's
+// public key to verify their checkpoints.
+func openLogSource(ctLogUrl string, tiledKeyPEM []byte) (LogSource, error) {
+	if tiledKeyPEM != nil {
+		tiledLog, err := tiledlog.New(ctLogUrl, tiledKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		return &tiledSource{tiledLog: tiledLog}, nil
+	}
+
+	ctLog, err := client.New(ctLogUrl, nil, jsonclient.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return &rfc6962Source{ctLog: ctLog}, nil
+}
+
+// fetchRawEntries makes a single get-entries request for [start, end],
+// returning a *fetchError on failure.
+func fetchRawEntries(ctx context.Context, ctLog *client.LogClient, start, end uint64) ([]client.LeafEntry, error) {
+	params := map[string]string{
+		"start": strconv.FormatUint(start, 10),
+		"end":   strconv.FormatUint(end, 10),
+	}
+
+	var resp client.GetEntriesResponse
+	httpRsp, err := ctLog.GetAndParse(ctx, client.GetEntriesPath, params, &resp)
+	if err != nil {
+		fetchErr := &fetchError{Err: err}
+		if httpRsp != nil {
+			fetchErr.StatusCode = httpRsp.StatusCode
+			fetchErr.RetryAfter = parseRetryAfter(httpRsp.Header.Get("Retry-After"))
+		}
+		return nil, fetchErr
+	}
+	return resp.Entries, nil
+}
+
+// parseLeafEntry decodes a raw get-entries result, the same way
+// client.GetEntries does.
+func parseLeafEntry(index uint64, entry client.LeafEntry) (*ct.LogEntry, error) {
+	leaf, err := ct.ReadMerkleTreeLeaf(bytes.NewBuffer(entry.LeafInput))
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []ct.ASN1Cert
+	switch leaf.TimestampedEntry.EntryType {
+	case ct.X509LogEntryType:
+		chain, err = ct.UnmarshalX509ChainArray(entry.ExtraData)
+	case ct.PrecertLogEntryType:
+		chain, err = ct.UnmarshalPrecertChainArray(entry.ExtraData)
+	default:
+		return nil, fmt.Errorf("saw unknown entry type: %v", leaf.TimestampedEntry.EntryType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ct.LogEntry{
+		Index: int64(index),
+		Leaf:  *leaf,
+		Chain: chain,
+	}, nil
+}
+
+func (ld *LogDownloader) limiterFor(logID int) *utils.TokenBucket {
+	ld.LimitersLock.Lock()
+	defer ld.LimitersLock.Unlock()
+
+	limiter, ok := ld.Limiters[logID]
+	if !ok {
+		limiter = utils.NewTokenBucket(*config.LogRateLimit, *config.LogRateBurst)
+		ld.Limiters[logID] = limiter
+	}
+	return limiter
+}
+
+// getEntries fetches [start, end] from the log, honouring the per-log rate
+// limit. Transient failures are retried with backoff, waiting at least as long
+// as the log's Retry-After, until config.MaxRetries is exhausted or ctx is
+// done.
+func (ld *LogDownloader) getEntries(ctx context.Context, logID int, source LogSource, start, end uint64) ([]client.LeafEntry, error) {
+	limiter := ld.limiterFor(logID)
+	retryBackoff := &backoff.Backoff{
+		Min:    1 * time.Second,
+		Max:    5 * time.Minute,
+		Jitter: true,
+	}
+
+	for attempt := 1; ; attempt++ {
+		limiter.Wait()
+
+		callStart := time.Now()
+		rawEnts, err := source.GetRawEntries(ctx, start, end)
+		ld.Health.Record(logID, sqldb.HealthGetEntries, callStart, err)
+		if err == nil {
+			return rawEnts, nil
+		}
+
+		fetchErr, ok := err.(*fetchError)
+		if !ok || !fetchErr.Transient() || attempt > *config.MaxRetries {
+			return nil, err
+		}
+
+		delay := retryBackoff.Duration()
+		if fetchErr.RetryAfter > delay {
+			delay = fetchErr.RetryAfter
+		}
+
+		if fetchErr.Throttled() {
+			limiter.Drain()
+			if dbErr := ld.Database.InsertThrottleEvent(logID, fetchErr.StatusCode, fetchErr.RetryAfter); dbErr != nil {
+				log.Printf("[log %d] Unable to record throttling event: %s", logID, dbErr)
+			}
+		}
+
+		log.Printf("[log %d] Fetching entries %d-%d failed, retrying in %s (%d/%d): %s",
+			logID, start, end, delay, attempt, *config.MaxRetries, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// parseLeafEntries decodes a batch of raw entries beginning at index start.
+// Entries that can't be decoded are recorded as failed, acknowledged to the
+// watermark, and left nil.
+func (ld *LogDownloader) parseLeafEntries(logID int, watermark *Watermark, start uint64, rawEnts []client.LeafEntry) []*ct.LogEntry {
+	entries := make([]*ct.LogEntry, len(rawEnts))
+	for i, rawEnt := range rawEnts {
+		index := start + uint64(i)
+		ent, err := parseLeafEntry(index, rawEnt)
+		if err != nil {
+			log.Printf("[log %d] Unable to decode entry %d: %s", logID, index, err)
+			dbErr := ld.Database.RecordFailedCTEntry(logID, index, 0, rawEnt.LeafInput, rawEnt.ExtraData, sqldb.LeafError{Err: err})
+			if dbErr != nil {
+				log.Printf("[log %d] Unable to record failed entry %d: %s", logID, index, dbErr)
+			}
+			watermark.Ack(index, 0)
+			continue
+		}
+		entries[i] = ent
+	}
+	return entries
+}
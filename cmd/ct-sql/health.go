@@ -0,0 +1,132 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jcjones/ct-sql/sqldb"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+
+// percentile expects d to be sorted
+func (d durations) percentile(p int) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	return d[(len(d)-1)*p/100]
+}
+
+type healthKey struct {
+	logID     int
+	operation string
+}
+
+type healthStats struct {
+	since     time.Time
+	latencies durations
+	errors    int
+	lastError string
+}
+
+// HealthMonitor times the calls made to each log, until they're summarized
+// into the log_health table. A nil HealthMonitor records nothing.
+type HealthMonitor struct {
+	lock     sync.Mutex
+	stats    map[healthKey]*healthStats
+	sthTimes map[int]uint64
+}
+
+func NewHealthMonitor() *HealthMonitor {
+	return &HealthMonitor{
+		stats:    make(map[healthKey]*healthStats),
+		sthTimes: make(map[int]uint64),
+	}
+}
+
+// Record notes one call to the log, which began at start.
+func (hm *HealthMonitor) Record(logID int, operation string, start time.Time, err error) {
+	if hm == nil {
+		return
+	}
+	hm.lock.Lock()
+	defer hm.lock.Unlock()
+
+	key := healthKey{logID, operation}
+	stats, ok := hm.stats[key]
+	if !ok {
+		stats = &healthStats{since: start}
+		hm.stats[key] = stats
+	}
+	stats.latencies = append(stats.latencies, time.Since(start))
+	if err != nil {
+		stats.errors++
+		stats.lastError = err.Error()
+	}
+}
+
+// RecordSTH notes the timestamp of a tree head the log served.
+func (hm *HealthMonitor) RecordSTH(logID int, timestamp uint64) {
+	if hm == nil {
+		return
+	}
+	hm.lock.Lock()
+	defer hm.lock.Unlock()
+	if timestamp > hm.sthTimes[logID] {
+		hm.sthTimes[logID] = timestamp
+	}
+}
+
+// Flush summarizes the calls made to the log since the last flush.
+func (hm *HealthMonitor) Flush(db *sqldb.EntriesDatabase, logID int) error {
+	if hm == nil {
+		return nil
+	}
+	hm.lock.Lock()
+	var summaries []*sqldb.LogHealth
+	now := time.Now()
+	for key, stats := range hm.stats {
+		if key.logID != logID {
+			continue
+		}
+		delete(hm.stats, key)
+
+		sort.Sort(stats.latencies)
+		summary := &sqldb.LogHealth{
+			LogID:       logID,
+			Operation:   key.operation,
+			PeriodStart: stats.since,
+			PeriodEnd:   now,
+			Calls:       len(stats.latencies),
+			Errors:      stats.errors,
+			P50Ms:       int64(stats.latencies.percentile(50) / time.Millisecond),
+			P95Ms:       int64(stats.latencies.percentile(95) / time.Millisecond),
+			P99Ms:       int64(stats.latencies.percentile(99) / time.Millisecond),
+			MaxMs:       int64(stats.latencies.percentile(100) / time.Millisecond),
+			LastError:   stats.lastError,
+		}
+		if sthTime, ok := hm.sthTimes[logID]; ok {
+			summary.STHTime = utils.Uint64ToTimestamp(sthTime)
+			summary.STHAge = int64(now.Sub(summary.STHTime).Seconds())
+		}
+		summaries = append(summaries, summary)
+	}
+	hm.lock.Unlock()
+
+	for _, summary := range summaries {
+		if err := db.InsertLogHealth(summary); err != nil {
+			return err
+		}
+	}
+	return nil
+}
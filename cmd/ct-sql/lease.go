@@ -0,0 +1,102 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/jcjones/ct-sql/sqldb"
+	"golang.org/x/net/context"
+)
+
+// leaseOwner identifies this process to others sharing the database
+var leaseOwner = func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%08x", hostname, os.Getpid(), rand.New(rand.NewSource(time.Now().UnixNano())).Uint32())
+}()
+
+// checkpointLease records coverage like checkpoint, but leaves the log's
+// MaxEntry to CompleteLease; progress is kept on the lease instead, and the
+// lease is renewed.
+func (ld *LogDownloader) checkpointLease(logObj *sqldb.CertificateLog, lease *sqldb.LogLease, watermark *Watermark, duration time.Duration) error {
+	from, committed := watermark.Advance()
+	err := ld.Database.RecordCoverage(logObj.LogID, from, committed)
+	if err != nil {
+		return err
+	}
+
+	if err := ld.Health.Flush(ld.Database, logObj.LogID); err != nil {
+		log.Printf("[log %d] Unable to record log health: %s", logObj.LogID, err)
+	}
+
+	_, lastTime := watermark.Committed()
+	return ld.Database.RenewLease(lease, committed, lastTime, duration)
+}
+
+// downloadLeases claims ranges of the log below treeSize and downloads them,
+// until none are left or config.Limit entries have been leased. Other
+// processes doing the same take the other ranges, or finish ours if our
+// lease expires.
+func (ld *LogDownloader) downloadLeases(ctx context.Context, ctLogUrl string, logObj *sqldb.CertificateLog, source LogSource, treeSize uint64) {
+	duration := time.Duration(*config.LeaseDuration) * time.Second
+	if duration <= 0 {
+		duration = 5 * time.Minute
+	}
+
+	var leased uint64
+	for *config.Limit == 0 || leased < *config.Limit {
+		lease, err := ld.Database.ClaimLease(logObj.LogID, leaseOwner, treeSize, *config.LeaseSize, duration)
+		if err != nil {
+			log.Printf("[%s] Unable to claim a lease: %s", ctLogUrl, err)
+			return
+		}
+		if lease == nil {
+			log.Printf("[%s] Nothing left to lease below %d\n", ctLogUrl, treeSize)
+			return
+		}
+		leased += lease.EndEntry - lease.Committed
+
+		if lease.Takeovers > 0 {
+			log.Printf("[%s] Took over expired lease from %d to %d, resuming at %d\n",
+				ctLogUrl, lease.StartEntry, lease.EndEntry, lease.Committed)
+		} else {
+			log.Printf("[%s] Leased %d to %d\n", ctLogUrl, lease.StartEntry, lease.EndEntry)
+		}
+
+		watermark := NewWatermark(lease.Committed)
+		finalIndex, err := ld.DownloadCTRangeToChannel(ctx, logObj, watermark, source, lease.Committed, lease.EndEntry, func() error {
+			return ld.checkpointLease(logObj, lease, watermark, duration)
+		})
+		watermark.WaitFor(finalIndex)
+
+		if cpErr := ld.checkpointLease(logObj, lease, watermark, duration); cpErr != nil && err == nil {
+			err = cpErr
+		}
+		if err == sqldb.ErrLeaseLost {
+			// Another process is finishing it; move on to the next range
+			log.Printf("[%s] Lost lease from %d to %d at %d\n", ctLogUrl, lease.StartEntry, lease.EndEntry, lease.Committed)
+			continue
+		}
+		if err != nil {
+			log.Printf("\n[%s] Lease from %d to %d halting, error caught: %s\n", ctLogUrl, lease.StartEntry, lease.EndEntry, err)
+			return
+		}
+
+		maxEntry, lastEntryTime, err := ld.Database.CompleteLease(lease)
+		if err != nil {
+			log.Printf("[%s] Unable to complete lease from %d to %d: %s", ctLogUrl, lease.StartEntry, lease.EndEntry, err)
+			return
+		}
+		logObj.MaxEntry, logObj.LastEntryTime = maxEntry, lastEntryTime
+		log.Printf("[%s] Completed lease from %d to %d. MaxEntry=%d", ctLogUrl, lease.StartEntry, lease.EndEntry, logObj.MaxEntry)
+	}
+}
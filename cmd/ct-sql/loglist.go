@@ -0,0 +1,82 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/jcjones/ct-sql/sqldb"
+)
+
+// The parts of a browser log list (log_list.json, version 3) we use
+type logList struct {
+	Operators []struct {
+		Name string `json:"name"`
+		Logs []struct {
+			Description      string `json:"description"`
+			Key              string `json:"key"`
+			URL              string `json:"url"`
+			MMD              int    `json:"mmd"`
+			TemporalInterval *struct {
+				StartInclusive time.Time `json:"start_inclusive"`
+				EndExclusive   time.Time `json:"end_exclusive"`
+			} `json:"temporal_interval"`
+		} `json:"logs"`
+	} `json:"operators"`
+}
+
+// loadLogList reads the logs from a log list file, recording each one's key,
+// operator, MMD and shard. Returns their URLs.
+func loadLogList(db *sqldb.EntriesDatabase, path string) ([]url.URL, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list logList
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("Unable to parse log list %s: %s", path, err)
+	}
+
+	var logUrls []url.URL
+	for _, operator := range list.Operators {
+		for _, entry := range operator.Logs {
+			urlParts, err := url.Parse(entry.URL)
+			if err != nil {
+				return nil, fmt.Errorf("Bad URL for %s: %s", entry.Description, err)
+			}
+			logObj, err := db.GetLogState(fmt.Sprintf("%s%s", urlParts.Host, urlParts.Path))
+			if err != nil {
+				return nil, err
+			}
+
+			logObj.Operator = operator.Name
+			logObj.MMD = entry.MMD
+
+			der, err := base64.StdEncoding.DecodeString(entry.Key)
+			if err != nil {
+				return nil, fmt.Errorf("Bad key for %s: %s", entry.Description, err)
+			}
+			keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+			if err = db.SetLogKey(logObj, keyPEM); err != nil {
+				return nil, fmt.Errorf("Bad key for %s: %s", entry.Description, err)
+			}
+			if entry.TemporalInterval != nil {
+				err = db.SetLogShard(logObj, entry.TemporalInterval.StartInclusive, entry.TemporalInterval.EndExclusive)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			logUrls = append(logUrls, *urlParts)
+		}
+	}
+	return logUrls, nil
+}
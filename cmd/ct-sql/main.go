@@ -9,27 +9,26 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
-	"os/signal"
 	"runtime"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
-	"golang.org/x/net/context"
 
 	_ "github.com/go-sql-driver/mysql"
 
 	"github.com/go-gorp/gorp"
 	"github.com/google/certificate-transparency/go"
 	"github.com/google/certificate-transparency/go/client"
-	"github.com/google/certificate-transparency/go/jsonclient"
+	"github.com/jcjones/ct-sql/archive"
 	"github.com/jcjones/ct-sql/censysdata"
 	"github.com/jcjones/ct-sql/sqldb"
 	"github.com/jcjones/ct-sql/utils"
 	"github.com/jpillora/backoff"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -37,8 +36,10 @@ var (
 )
 
 type CtLogEntry struct {
-	LogEntry *ct.LogEntry
-	LogID    int
+	LogEntry  *ct.LogEntry
+	Raw       client.LeafEntry
+	LogID     int
+	Watermark *Watermark
 }
 
 type LogDownloader struct {
@@ -48,6 +49,10 @@ type LogDownloader struct {
 	ThreadWaitGroup     *sync.WaitGroup
 	DownloaderWaitGroup *sync.WaitGroup
 	Backoff             *backoff.Backoff
+	Limiters            map[int]*utils.TokenBucket
+	LimitersLock        sync.Mutex
+	Archive             *archive.Archive
+	Health              *HealthMonitor
 }
 
 func NewLogDownloader(db *sqldb.EntriesDatabase) *LogDownloader {
@@ -62,50 +67,71 @@ func NewLogDownloader(db *sqldb.EntriesDatabase) *LogDownloader {
 			Max:    1 * time.Second,
 			Jitter: true,
 		},
+		Limiters: make(map[int]*utils.TokenBucket),
 	}
 }
 
-func (ld *LogDownloader) StartThreads() {
+func (ld *LogDownloader) StartThreads(ctx context.Context) {
 	numWorkers := *config.NumThreads * runtime.NumCPU()
 	for i := 0; i < numWorkers; i++ {
-		go ld.insertCTWorker()
+		go ld.insertCTWorker(ctx)
 	}
 }
 
+// Stop waits for the downloaders to finish handing out entries, then lets
+// the workers drain what's left and exit.
 func (ld *LogDownloader) Stop() {
+	ld.DownloaderWaitGroup.Wait()
 	close(ld.EntryChan)
 	ld.Display.Close()
+	if err := ld.Archive.Close(); err != nil {
+		log.Printf("Unable to close archive: %s", err)
+	}
 }
 
-func (ld *LogDownloader) Download(ctLogUrl string) {
+// Download fetches the log's entries from where we last left off up to its
+// current tree head. Returns what was seen of the log, or nil if its tree
+// head couldn't be fetched.
+func (ld *LogDownloader) Download(ctx context.Context, ctLogUrl string, source LogSource) *LogPoll {
 	if *config.OffsetByte > 0 {
 		log.Printf("[%s] Cannot set offsetByte for CT log downloads", ctLogUrl)
-		return
+		return nil
 	}
 
-	ctLog, err := client.New(ctLogUrl, nil, jsonclient.Options{})
+	urlParts, err := url.Parse(ctLogUrl)
 	if err != nil {
-		log.Printf("[%s] Unable to construct CT log client: %s", ctLogUrl, err)
-		return
+		log.Printf("[%s] Unable to parse Certificate Log: %s", ctLogUrl, err)
+		return nil
+	}
+	logObj, err := ld.Database.GetLogState(fmt.Sprintf("%s%s", urlParts.Host, urlParts.Path))
+	if err != nil {
+		log.Printf("[%s] Unable to set Certificate Log: %s", ctLogUrl, err)
+		return nil
 	}
+	defer func() {
+		if err := ld.Health.Flush(ld.Database, logObj.LogID); err != nil {
+			log.Printf("[%s] Unable to record log health: %s", ctLogUrl, err)
+		}
+	}()
 
 	log.Printf("[%s] Fetching signed tree head... ", ctLogUrl)
-	sth, err := ctLog.GetSTH(context.Background())
+	callStart := time.Now()
+	treeSize, timestamp, err := source.GetTreeHead(ctx)
+	ld.Health.Record(logObj.LogID, sqldb.HealthGetSTH, callStart, err)
 	if err != nil {
 		log.Printf("[%s] Unable to fetch signed tree head: %s", ctLogUrl, err)
-		return
+		return nil
 	}
+	ld.Health.RecordSTH(logObj.LogID, timestamp)
 
-	// Set pointer in DB, now that we've verified the log works
-	urlParts, err := url.Parse(ctLogUrl)
-	if err != nil {
-		log.Printf("[%s] Unable to parse Certificate Log: %s", ctLogUrl, err)
-		return
-	}
-	logObj, err := ld.Database.GetLogState(fmt.Sprintf("%s%s", urlParts.Host, urlParts.Path))
-	if err != nil {
-		log.Printf("[%s] Unable to set Certificate Log: %s", ctLogUrl, err)
-		return
+	poll := &LogPoll{TreeSize: treeSize, Timestamp: timestamp, Polled: time.Now()}
+	defer func() {
+		poll.MaxEntry = logObj.MaxEntry
+	}()
+
+	if *config.LeaseSize > 0 {
+		ld.downloadLeases(ctx, ctLogUrl, logObj, source, treeSize)
+		return poll
 	}
 
 	var origCount uint64
@@ -118,55 +144,93 @@ func (ld *LogDownloader) Download(ctLogUrl string) {
 		origCount = logObj.MaxEntry
 		if err != nil {
 			log.Printf("[%s] Failed to read entries file: %s", ctLogUrl, err)
-			return
+			return poll
 		}
 	}
 
-	log.Printf("[%s] %d total entries at %s\n", ctLogUrl, sth.TreeSize, utils.Uint64ToTimestamp(sth.Timestamp).Format(time.ANSIC))
-	if origCount == sth.TreeSize {
+	log.Printf("[%s] %d total entries at %s\n", ctLogUrl, treeSize, utils.Uint64ToTimestamp(timestamp).Format(time.ANSIC))
+	if origCount == treeSize {
 		log.Printf("[%s] Nothing to do\n", ctLogUrl)
-		return
+		return poll
 	}
 
-	endPos := sth.TreeSize
+	endPos := treeSize
 	if *config.Limit > 0 && endPos > origCount+*config.Limit {
 		endPos = origCount + *config.Limit
 	}
 
 	log.Printf("[%s] Going from %d to %d\n", ctLogUrl, origCount, endPos)
 
-	finalIndex, finalTime, err := ld.DownloadCTRangeToChannel(logObj.LogID, ctLog, origCount, endPos)
+	watermark := NewWatermark(origCount)
+	finalIndex, err := ld.DownloadCTRangeToChannel(ctx, logObj, watermark, source, origCount, endPos, func() error {
+		return ld.checkpoint(logObj, watermark)
+	})
 	if err != nil {
 		log.Printf("\n[%s] Download halting, error caught: %s\n", ctLogUrl, err)
 	}
 
-	logObj.MaxEntry = finalIndex
-	if finalTime != 0 {
-		logObj.LastEntryTime = utils.Uint64ToTimestamp(finalTime)
-	}
+	// Let the workers finish everything we handed them before the final
+	// checkpoint, so nothing in flight is skipped on the next run.
+	watermark.WaitFor(finalIndex)
 
+	err = ld.checkpoint(logObj, watermark)
+	if err != nil {
+		log.Printf("[%s] Unable to save state: %s", ctLogUrl, err)
+		return poll
+	}
 	log.Printf("[%s] Saved state. MaxEntry=%d, LastEntryTime=%s", ctLogUrl, logObj.MaxEntry, logObj.LastEntryTime)
-	ld.Database.SaveLogState(logObj)
+	return poll
+}
+
+// checkpoint records the entries committed since the last checkpoint as
+// covered, and moves the log's MaxEntry up to the committed watermark.
+// MaxEntry only ever advances, so backfilling old ranges leaves it alone.
+// The log's health since the last checkpoint is recorded too.
+func (ld *LogDownloader) checkpoint(logObj *sqldb.CertificateLog, watermark *Watermark) error {
+	from, committed := watermark.Advance()
+	err := ld.Database.RecordCoverage(logObj.LogID, from, committed)
+	if err != nil {
+		return err
+	}
+
+	if err := ld.Health.Flush(ld.Database, logObj.LogID); err != nil {
+		log.Printf("[log %d] Unable to record log health: %s", logObj.LogID, err)
+	}
+
+	if committed <= logObj.MaxEntry {
+		return nil
+	}
+
+	_, lastTime := watermark.Committed()
+	logObj.MaxEntry = committed
+	if lastTime != 0 {
+		logObj.LastEntryTime = utils.Uint64ToTimestamp(lastTime)
+	}
+	return ld.Database.SaveLogState(logObj)
 }
 
 // DownloadRange downloads log entries from the given starting index till one
-// less than upTo. If status is not nil then status updates will be written to
-// it until the function is complete, when it will be closed. The log entries
-// are provided to an output channel.
-func (ld *LogDownloader) DownloadCTRangeToChannel(logID int, ctLog *client.LogClient, start, upTo uint64) (uint64, uint64, error) {
+// less than upTo. The log entries are provided to an output channel, and the
+// workers acknowledge them to the watermark, which is periodically
+// checkpointed to the database by calling checkpoint. Returns the index of the
+// first entry that was not handed to the workers. Stops early when ctx is
+// done.
+func (ld *LogDownloader) DownloadCTRangeToChannel(ctx context.Context, logObj *sqldb.CertificateLog, watermark *Watermark, source LogSource, start, upTo uint64, checkpoint func() error) (uint64, error) {
 	if ld.EntryChan == nil {
-		return start, 0, fmt.Errorf("No output channel provided")
+		return start, fmt.Errorf("No output channel provided")
 	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
-	defer signal.Stop(sigChan)
-	defer close(sigChan)
+	logID := logObj.LogID
 
 	progressTicker := time.NewTicker(10 * time.Second)
 	defer progressTicker.Stop()
 
-	var lastTime uint64
+	checkpointInterval := time.Duration(*config.CheckpointInterval) * time.Second
+	if checkpointInterval <= 0 {
+		checkpointInterval = time.Minute
+	}
+	checkpointTicker := time.NewTicker(checkpointInterval)
+	defer checkpointTicker.Stop()
 
 	index := start
 	for index < upTo {
@@ -174,28 +238,41 @@ func (ld *LogDownloader) DownloadCTRangeToChannel(logID int, ctLog *client.LogCl
 		if max >= upTo {
 			max = upTo - 1
 		}
-		rawEnts, err := ctLog.GetEntries(int64(index), int64(max))
+		rawEnts, err := ld.getEntries(ctx, logID, source, index, max)
 		if err != nil {
-			return index, lastTime, err
+			return index, err
 		}
+		if err = ld.Archive.Append(logID, index, rawEnts); err != nil {
+			return index, fmt.Errorf("Unable to archive entries: %s", err)
+		}
+		ents := ld.parseLeafEntries(logID, watermark, index, rawEnts)
 
-		for arrayOffset := 0; arrayOffset < len(rawEnts); {
-			ent := rawEnts[arrayOffset]
-			// Are there waiting signals?
+		for arrayOffset := 0; arrayOffset < len(ents); {
+			ent := ents[arrayOffset]
+			if ent == nil {
+				// Undecodable, already recorded as failed
+				index++
+				arrayOffset++
+				continue
+			}
+			// Are we stopping?
 			select {
-			case sig := <-sigChan:
-				return index, lastTime, fmt.Errorf("Signal caught: %s", sig)
-			case ld.EntryChan <- CtLogEntry{&ent, logID}:
-				lastTime = ent.Leaf.TimestampedEntry.Timestamp
-				if uint64(ent.Index) != index {
-					return index, lastTime, fmt.Errorf("Index mismatch, local: %v, remote: %v", index, ent.Index)
-				}
-
+			case <-ctx.Done():
+				return index, ctx.Err()
+			case ld.EntryChan <- CtLogEntry{ent, rawEnts[arrayOffset], logID, watermark}:
 				index++
 				arrayOffset++
 				ld.Backoff.Reset()
 			case <-progressTicker.C:
 				ld.Display.UpdateProgress(fmt.Sprintf("%d", logID), start, index, upTo)
+			case <-checkpointTicker.C:
+				err := checkpoint()
+				if err == sqldb.ErrLeaseLost {
+					return index, err
+				}
+				if err != nil {
+					log.Printf("[log %d] Unable to checkpoint: %s", logID, err)
+				}
 			default:
 				// Channel full, retry
 				time.Sleep(ld.Backoff.Duration())
@@ -203,21 +280,31 @@ func (ld *LogDownloader) DownloadCTRangeToChannel(logID int, ctLog *client.LogCl
 		}
 	}
 
-	return index, lastTime, nil
+	return index, nil
 }
 
-func (ld *LogDownloader) insertCTWorker() {
+func (ld *LogDownloader) insertCTWorker(ctx context.Context) {
 	ld.ThreadWaitGroup.Add(1)
 	defer ld.ThreadWaitGroup.Done()
 	for ep := range ld.EntryChan {
-		err := ld.Database.InsertCTEntry(ep.LogEntry, ep.LogID)
+		err := ld.Database.InsertCTEntry(ctx, ep.LogEntry, ep.LogID)
 		if err != nil {
-			log.Printf("Problem inserting certificate: index: %d log: %s error: %s", ep.LogEntry.Index, *config.LogUrl, err)
+			log.Printf("Problem inserting certificate: index: %d log: %d error: %s", ep.LogEntry.Index, ep.LogID, err)
+			dbErr := ld.Database.RecordFailedCTEntry(ep.LogID, uint64(ep.LogEntry.Index), ep.LogEntry.Leaf.TimestampedEntry.Timestamp,
+				ep.Raw.LeafInput, ep.Raw.ExtraData, err)
+			if dbErr != nil {
+				log.Printf("Problem recording failed entry: index: %d log: %d error: %s", ep.LogEntry.Index, ep.LogID, dbErr)
+			}
 		}
+		// Failures are acknowledged too, otherwise one bad entry would hold
+		// the checkpoint back forever.
+		ep.Watermark.Ack(uint64(ep.LogEntry.Index), ep.LogEntry.Leaf.TimestampedEntry.Timestamp)
 	}
 }
 
-func processImporter(importer censysdata.Importer, db *sqldb.EntriesDatabase, wg *sync.WaitGroup) error {
+// processImporter hands the importer's entries to insert workers until it's
+// exhausted, the limit is reached, or ctx is done.
+func processImporter(ctx context.Context, importer censysdata.Importer, db *sqldb.EntriesDatabase, wg *sync.WaitGroup) error {
 	entryChan := make(chan censysdata.CensysEntry)
 	defer close(entryChan)
 	wg.Add(1)
@@ -229,7 +316,7 @@ func processImporter(importer censysdata.Importer, db *sqldb.EntriesDatabase, wg
 
 	numWorkers := *config.NumThreads * runtime.NumCPU()
 	for i := 0; i < numWorkers; i++ {
-		go insertCensysWorker(entryChan, db, wg)
+		go insertCensysWorker(ctx, entryChan, db, wg)
 	}
 
 	startOffset := *config.OffsetByte
@@ -279,20 +366,26 @@ func processImporter(importer censysdata.Importer, db *sqldb.EntriesDatabase, wg
 
 			display.UpdateProgress("importer", startOffset, ent.Offset, maxOffset)
 		}
-		entryChan <- *ent
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping import at offset=%d", ent.Offset)
+			return ctx.Err()
+		case entryChan <- *ent:
+		}
 	}
-
-	return nil
 }
 
-func insertCensysWorker(entries <-chan censysdata.CensysEntry, db *sqldb.EntriesDatabase, wg *sync.WaitGroup) {
+func insertCensysWorker(ctx context.Context, entries <-chan censysdata.CensysEntry, db *sqldb.EntriesDatabase, wg *sync.WaitGroup) {
 	wg.Add(1)
 	defer wg.Done()
 	for ep := range entries {
 		if ep.Valid_nss {
-			err := db.InsertCensysEntry(&ep)
+			err := db.InsertCensysEntry(ctx, &ep)
 			if err != nil {
 				log.Printf("Problem inserting certificate: index: %d error: %s", ep.Offset, err)
+				if dbErr := db.RecordFailedCensysEntry(&ep, err); dbErr != nil {
+					log.Printf("Problem recording failed entry: index: %d error: %s", ep.Offset, dbErr)
+				}
 			}
 		}
 	}
@@ -311,6 +404,8 @@ func main() {
 		os.Exit(2)
 	}
 
+	ctx := utils.SignalContext(time.Duration(*config.ShutdownTimeout) * time.Second)
+
 	db, err := sql.Open("mysql", dbConnectStr)
 	if err != nil {
 		log.Fatalf("unable to open SQL: %s: %s", dbConnectStr, err)
@@ -323,7 +418,7 @@ func main() {
 	if config.CertPath != nil && len(*config.CertPath) > 0 {
 		certFolderDB, err = utils.NewFolderDatabase(*config.CertPath, 0444, *config.CertsPerFolder)
 		if err != nil {
-			log.Fatalf("unable to open Certificate Path: %s: %s", config.CertPath, err)
+			log.Fatalf("unable to open Certificate Path: %s: %s", *config.CertPath, err)
 		}
 	}
 
@@ -345,6 +440,15 @@ func main() {
 		}
 	}
 
+	excludedPurposes, err := sqldb.ParsePurposes(*config.ExcludePurposes)
+	if err != nil {
+		log.Fatalf("unable to parse ExcludePurposes: %s", err)
+	}
+	unnamedPurposes, err := sqldb.ParsePurposes(*config.ExcludeNamePurposes)
+	if err != nil {
+		log.Fatalf("unable to parse ExcludeNamePurposes: %s", err)
+	}
+
 	dialect := gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}
 	dbMap := &gorp.DbMap{Db: db, Dialect: dialect}
 	entriesDb := &sqldb.EntriesDatabase{
@@ -357,13 +461,87 @@ func main() {
 		EarliestDateFilter:  earliestDate,
 		CorrelateLogEntries: *config.CorrelateLogEntries,
 		LogExpiredEntries:   *config.LogExpiredEntries,
+		ExcludedPurposes:    excludedPurposes,
+		UnnamedPurposes:     unnamedPurposes,
 	}
 	err = entriesDb.InitTables()
 	if err != nil {
 		log.Fatalf("unable to prepare SQL: %s: %s", dbConnectStr, err)
 	}
 
+	if *config.KnownCertFilter > 0 {
+		count, err := entriesDb.LoadKnownCertificates(*config.KnownCertFilter)
+		if err != nil {
+			log.Fatalf("unable to load known certificates: %s", err)
+		}
+		log.Printf("Loaded %d known certificates", count)
+	}
+
+	if *config.RetryFailed {
+		err = retryFailedEntries(ctx, entriesDb)
+		if err != nil {
+			log.Fatalf("error while retrying failed entries: %s", err)
+		}
+		os.Exit(0)
+	}
+
+	if *config.ReprocessUnparsed {
+		err = reprocessUnparseable(ctx, entriesDb)
+		if err != nil {
+			log.Fatalf("error while reprocessing unparseable entries: %s", err)
+		}
+		os.Exit(0)
+	}
+
+	if *config.BackfillSPKI {
+		err = backfillSPKI(ctx, entriesDb)
+		if err != nil {
+			log.Fatalf("error while backfilling key hashes: %s", err)
+		}
+		os.Exit(0)
+	}
+
+	if *config.BackfillFingerprint {
+		err = backfillFingerprints(ctx, entriesDb)
+		if err != nil {
+			log.Fatalf("error while backfilling fingerprints: %s", err)
+		}
+		os.Exit(0)
+	}
+
+	var entryArchive *archive.Archive
+	if config.ArchivePath != nil && len(*config.ArchivePath) > 0 {
+		entryArchive, err = archive.New(*config.ArchivePath)
+		if err != nil {
+			log.Fatalf("unable to open archive: %s", err)
+		}
+	}
+
+	if *config.FromArchive {
+		if entryArchive == nil {
+			log.Fatalf("fromArchive requires archivePath")
+		}
+		logIDs, err := entryArchive.Logs()
+		if err != nil {
+			log.Fatalf("unable to read archive: %s", err)
+		}
+
+		logDownloader := NewLogDownloader(entriesDb)
+		logDownloader.Display.StartDisplay(logDownloader.ThreadWaitGroup)
+		logDownloader.StartThreads(ctx)
+		for _, logID := range logIDs {
+			if ctx.Err() != nil {
+				break
+			}
+			logDownloader.Replay(ctx, entryArchive, logID)
+		}
+		logDownloader.Stop()
+		logDownloader.ThreadWaitGroup.Wait()
+		os.Exit(0)
+	}
+
 	logUrls := []url.URL{}
+	logKeys := make(map[string][]byte)
 
 	if config.LogUrl != nil && len(*config.LogUrl) > 5 {
 		ctLogUrl, err := url.Parse(*config.LogUrl)
@@ -371,6 +549,14 @@ func main() {
 			log.Fatalf("unable to set Certificate Log: %s", err)
 		}
 		logUrls = append(logUrls, *ctLogUrl)
+
+		if config.LogKey != nil && len(*config.LogKey) > 0 {
+			keyPEM, err := ioutil.ReadFile(*config.LogKey)
+			if err != nil {
+				log.Fatalf("unable to read log key: %s", err)
+			}
+			logKeys[ctLogUrl.String()] = keyPEM
+		}
 	}
 
 	if config.LogUrlList != nil && len(*config.LogUrlList) > 5 {
@@ -383,52 +569,142 @@ func main() {
 		}
 	}
 
+	// Tiled logs need their public key to verify checkpoints
+	tiledKeys := make(map[string][]byte)
+	if config.TiledLogUrl != nil && len(*config.TiledLogUrl) > 5 {
+		ctLogUrl, err := url.Parse(*config.TiledLogUrl)
+		if err != nil {
+			log.Fatalf("unable to set tiled Certificate Log: %s", err)
+		}
+		if config.TiledLogKey == nil || len(*config.TiledLogKey) == 0 {
+			log.Fatalf("tiledLog requires tiledLogKey")
+		}
+		keyPEM, err := ioutil.ReadFile(*config.TiledLogKey)
+		if err != nil {
+			log.Fatalf("unable to read tiled log key: %s", err)
+		}
+		logUrls = append(logUrls, *ctLogUrl)
+		tiledKeys[ctLogUrl.String()] = keyPEM
+		logKeys[ctLogUrl.String()] = keyPEM
+	}
+
+	// Record the keys and operators we were given, so SCTs from these logs
+	// are attributed to them
+	for logUrl, keyPEM := range logKeys {
+		urlParts, err := url.Parse(logUrl)
+		if err != nil {
+			log.Fatalf("unable to set Certificate Log: %s", err)
+		}
+		logObj, err := entriesDb.GetLogState(fmt.Sprintf("%s%s", urlParts.Host, urlParts.Path))
+		if err != nil {
+			log.Fatalf("unable to set Certificate Log: %s", err)
+		}
+		if config.LogOperator != nil && len(*config.LogOperator) > 0 {
+			logObj.Operator = *config.LogOperator
+		}
+		// So that failed entries can be fetched again the same way
+		logObj.Tiled = tiledKeys[logUrl] != nil
+		if err = entriesDb.SetLogKey(logObj, keyPEM); err != nil {
+			log.Fatalf("unable to set key for %s: %s", logUrl, err)
+		}
+	}
+
+	// Sharded logs only hold certificates expiring within their shard
+	if config.LogShard != nil && len(*config.LogShard) > 0 {
+		shardStart, shardEnd, err := sqldb.ParseShard(*config.LogShard)
+		if err != nil {
+			log.Fatalf("unable to parse logShard: %s", err)
+		}
+		for _, logUrl := range []*string{config.LogUrl, config.TiledLogUrl} {
+			if logUrl == nil || len(*logUrl) <= 5 {
+				continue
+			}
+			urlParts, err := url.Parse(*logUrl)
+			if err != nil {
+				log.Fatalf("unable to set Certificate Log: %s", err)
+			}
+			logObj, err := entriesDb.GetLogState(fmt.Sprintf("%s%s", urlParts.Host, urlParts.Path))
+			if err != nil {
+				log.Fatalf("unable to set Certificate Log: %s", err)
+			}
+			if err = entriesDb.SetLogShard(logObj, shardStart, shardEnd); err != nil {
+				log.Fatalf("unable to set shard for %s: %s", *logUrl, err)
+			}
+		}
+	}
+
+	if config.LogListJson != nil && len(*config.LogListJson) > 0 {
+		listUrls, err := loadLogList(entriesDb, *config.LogListJson)
+		if err != nil {
+			log.Fatalf("unable to load log list: %s", err)
+		}
+		logUrls = append(logUrls, listUrls...)
+	}
+
 	if len(logUrls) > 0 {
 		logDownloader := NewLogDownloader(entriesDb)
+		logDownloader.Archive = entryArchive
+		logDownloader.Health = NewHealthMonitor()
 		logDownloader.Display.StartDisplay(logDownloader.ThreadWaitGroup)
-		logDownloader.StartThreads()
+		logDownloader.StartThreads(ctx)
+
+		var scheduler *Scheduler
+		if *config.RunForever && !*config.Backfill {
+			scheduler = NewScheduler(logDownloader,
+				time.Duration(*config.MinPollingDelay)*time.Second,
+				time.Duration(*config.PollingDelay)*time.Minute,
+				*config.MaxDownloads)
+		}
 
 		for _, ctLogUrl := range logUrls {
 			urlString := ctLogUrl.String()
+			logObj, err := entriesDb.GetLogState(fmt.Sprintf("%s%s", ctLogUrl.Host, ctLogUrl.Path))
+			if err != nil {
+				log.Printf("[%s] Unable to set Certificate Log: %s", urlString, err)
+				continue
+			}
+			if entriesDb.ShardIsFilteredOut(logObj) {
+				log.Printf("[%s] Skipping, every certificate in shard [%s, %s) would be filtered out\n",
+					urlString, logObj.ShardStart.Format("2006-01-02"), logObj.ShardEnd.Format("2006-01-02"))
+				continue
+			}
+
 			log.Printf("[%s] Starting download. FullCerts=%t\n", urlString, (certFolderDB != nil))
+			source, err := openLogSource(urlString, tiledKeys[urlString])
+			if err != nil {
+				log.Printf("[%s] Unable to construct CT log client: %s", urlString, err)
+				continue
+			}
+
+			if scheduler != nil {
+				scheduler.Add(urlString, source)
+				continue
+			}
 
 			logDownloader.DownloaderWaitGroup.Add(1)
 			go func() {
 				defer logDownloader.DownloaderWaitGroup.Done()
 
-				sigChan := make(chan os.Signal, 1)
-				signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
-				defer signal.Stop(sigChan)
-				defer close(sigChan)
-
-				for {
-					logDownloader.Download(urlString)
-					if !*config.RunForever {
-						return
-					}
-					sleepTime := time.Duration(*config.PollingDelay) * time.Minute
-					log.Printf("[%s] Completed. Polling again in %s.\n", urlString, sleepTime)
-
-					select {
-					case <-sigChan:
-						log.Printf("[%s] Signal caught.\n", urlString)
-						return
-					case <-time.After(sleepTime):
-						continue
-					}
+				if *config.Backfill {
+					logDownloader.Backfill(ctx, urlString, source)
+					return
 				}
+				logDownloader.Download(ctx, urlString, source)
 			}()
 		}
 
-		logDownloader.DownloaderWaitGroup.Wait() // Wait for downloaders to stop
-		logDownloader.Stop()                     // Stop workers
-		logDownloader.ThreadWaitGroup.Wait()     // Wait for workers to stop
+		if scheduler != nil {
+			scheduler.Run(ctx)
+		}
+
+		logDownloader.Stop()                 // Wait for downloaders, then stop workers
+		logDownloader.ThreadWaitGroup.Wait() // Wait for workers to drain
 		os.Exit(0)
 	}
 
 	var importer censysdata.Importer
 	if config.CensysUrl != nil && len(*config.CensysUrl) > 5 {
-		urlImporter, err := censysdata.OpenURL(*config.CensysUrl)
+		urlImporter, err := censysdata.OpenURL(ctx, *config.CensysUrl)
 		if err != nil {
 			log.Fatalf("unable to open Censys URL: %s", err)
 		}
@@ -453,9 +729,9 @@ func main() {
 		log.Printf("Starting Censys Import, using %s, fullCerts=%t\n", importer.String(), (certFolderDB != nil))
 
 		wg := new(sync.WaitGroup)
-		err = processImporter(importer, entriesDb, wg)
+		err = processImporter(ctx, importer, entriesDb, wg)
 
-		if err != nil {
+		if err != nil && err != context.Canceled {
 			log.Fatalf("error while running importer: %s", err)
 		}
 
@@ -0,0 +1,125 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/jcjones/ct-sql/archive"
+	"github.com/jcjones/ct-sql/sqldb"
+	"golang.org/x/net/context"
+)
+
+// archiveSource reads a log's entries back out of the local archive, in
+// place of the log itself.
+type archiveSource struct {
+	archive       *archive.Archive
+	blocks        []archive.Block
+	cached        archive.Block
+	cachedEntries []client.LeafEntry
+}
+
+func (src *archiveSource) GetTreeHead(ctx context.Context) (uint64, uint64, error) {
+	var treeSize uint64
+	for _, block := range src.blocks {
+		if block.End() > treeSize {
+			treeSize = block.End()
+		}
+	}
+	return treeSize, 0, nil
+}
+
+// blockFor finds a block holding index. Blocks may overlap where a range was
+// downloaded more than once; any of them will do.
+func (src *archiveSource) blockFor(index uint64) (archive.Block, bool) {
+	i := sort.Search(len(src.blocks), func(i int) bool {
+		return src.blocks[i].Start > index
+	})
+	for i--; i >= 0; i-- {
+		if index < src.blocks[i].End() {
+			return src.blocks[i], true
+		}
+	}
+	return archive.Block{}, false
+}
+
+func (src *archiveSource) GetRawEntries(ctx context.Context, start, end uint64) ([]client.LeafEntry, error) {
+	entries := make([]client.LeafEntry, 0, end-start+1)
+	for index := start; index <= end; {
+		block, ok := src.blockFor(index)
+		if !ok {
+			return nil, fmt.Errorf("Entry %d is not in the archive", index)
+		}
+
+		// Batches are requested in order, so consecutive requests
+		// usually land in the same block
+		if src.cachedEntries == nil || src.cached != block {
+			blockEntries, err := src.archive.ReadBlock(block)
+			if err != nil {
+				return nil, err
+			}
+			src.cached, src.cachedEntries = block, blockEntries
+		}
+
+		for ; index <= end && index < block.End(); index++ {
+			entries = append(entries, src.cachedEntries[index-block.Start])
+		}
+	}
+	return entries, nil
+}
+
+// archivedRanges merges the blocks into the contiguous ranges they cover.
+func archivedRanges(blocks []archive.Block) []sqldb.IndexRange {
+	var ranges []sqldb.IndexRange
+	for _, block := range blocks {
+		last := len(ranges) - 1
+		if last >= 0 && block.Start <= ranges[last].End {
+			if block.End() > ranges[last].End {
+				ranges[last].End = block.End()
+			}
+			continue
+		}
+		ranges = append(ranges, sqldb.IndexRange{Start: block.Start, End: block.End()})
+	}
+	return ranges
+}
+
+// Replay feeds every archived entry of the log through the insert workers,
+// applying whatever filters are configured now, without contacting the log.
+func (ld *LogDownloader) Replay(ctx context.Context, arch *archive.Archive, logID int) {
+	logObj, err := ld.Database.GetLogByID(logID)
+	if err != nil {
+		log.Printf("[log %d] Unable to find Certificate Log: %s", logID, err)
+		return
+	}
+
+	blocks, err := arch.Blocks(logID)
+	if err != nil {
+		log.Printf("[log %d] Unable to read archive: %s", logID, err)
+		return
+	}
+
+	source := &archiveSource{archive: arch, blocks: blocks}
+	for _, run := range archivedRanges(blocks) {
+		log.Printf("[%s] Replaying from %d to %d\n", logObj.URL, run.Start, run.End)
+
+		watermark := NewWatermark(run.Start)
+		finalIndex, err := ld.DownloadCTRangeToChannel(ctx, logObj, watermark, source, run.Start, run.End, func() error {
+			return ld.checkpoint(logObj, watermark)
+		})
+		watermark.WaitFor(finalIndex)
+
+		if cpErr := ld.checkpoint(logObj, watermark); cpErr != nil {
+			log.Printf("[%s] Unable to save state: %s", logObj.URL, cpErr)
+		}
+		if err != nil {
+			log.Printf("\n[%s] Replay halting, error caught: %s\n", logObj.URL, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"log"
+
+	"github.com/google/certificate-transparency/go/client"
+	"github.com/jcjones/ct-sql/censysdata"
+	"github.com/jcjones/ct-sql/sqldb"
+	"golang.org/x/net/context"
+)
+
+// retryFailedEntries makes another attempt at each entry in the failed_entry
+// table, removing the ones that now insert cleanly.
+func retryFailedEntries(ctx context.Context, db *sqldb.EntriesDatabase) error {
+	failures, err := db.GetFailedEntries(*config.Limit)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Retrying %d failed entries", len(failures))
+
+	sources := make(map[int]LogSource)
+	var resolved int
+	for i := range failures {
+		if ctx.Err() != nil {
+			log.Printf("Stopping after %d failed entries", i)
+			break
+		}
+		failure := &failures[i]
+
+		err = retryFailedEntry(ctx, db, sources, failure)
+		if err != nil {
+			if *config.Verbose {
+				log.Printf("Entry %s/%d/%d still failing: %s", failure.Source, failure.LogID, failure.EntryID, err)
+			}
+			if dbErr := db.UpdateFailedEntry(failure, err); dbErr != nil {
+				return dbErr
+			}
+			continue
+		}
+
+		if err = db.ResolveFailedEntry(failure); err != nil {
+			return err
+		}
+		resolved++
+	}
+
+	log.Printf("Resolved %d of %d failed entries", resolved, len(failures))
+	return nil
+}
+
+// retryFailedEntry re-parses the stored bytes and inserts them again. Log
+// entries that couldn't be decoded are fetched afresh from the log, in case
+// they were damaged in transit.
+func retryFailedEntry(ctx context.Context, db *sqldb.EntriesDatabase, sources map[int]LogSource, failure *sqldb.FailedEntry) error {
+	switch failure.Source {
+	case sqldb.FailureSourceCensys:
+		entry := &censysdata.CensysEntry{
+			Valid_nss: true,
+			CertBytes: failure.LeafInput,
+			Offset:    failure.EntryID,
+			Timestamp: &failure.EntryTime,
+		}
+		return db.InsertCensysEntry(ctx, entry)
+
+	case sqldb.FailureSourceCT:
+		raw := client.LeafEntry{
+			LeafInput: failure.LeafInput,
+			ExtraData: failure.ExtraData,
+		}
+
+		if failure.ErrorClass == sqldb.FailureClassLeaf || len(raw.LeafInput) == 0 {
+			source, err := logSourceForID(db, sources, failure.LogID)
+			if err != nil {
+				return err
+			}
+			rawEnts, err := source.GetRawEntries(ctx, failure.EntryID, failure.EntryID)
+			if err != nil {
+				return err
+			}
+			if len(rawEnts) != 1 {
+				return fmt.Errorf("Expected 1 entry from log, got %d", len(rawEnts))
+			}
+			raw = rawEnts[0]
+			failure.LeafInput = raw.LeafInput
+			failure.ExtraData = raw.ExtraData
+		}
+
+		ent, err := parseLeafEntry(failure.EntryID, raw)
+		if err != nil {
+			return sqldb.LeafError{Err: err}
+		}
+		return db.InsertCTEntry(ctx, ent, failure.LogID)
+	}
+
+	return fmt.Errorf("Unknown failed entry source: %s", failure.Source)
+}
+
+// logSourceForID opens the log as it was downloaded, through the Static CT
+// API with its stored key if it's tiled.
+func logSourceForID(db *sqldb.EntriesDatabase, sources map[int]LogSource, logID int) (LogSource, error) {
+	if source, ok := sources[logID]; ok {
+		return source, nil
+	}
+
+	logObj, err := db.GetLogByID(logID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tiledKeyPEM []byte
+	if logObj.Tiled {
+		if len(logObj.PublicKey) == 0 {
+			return nil, fmt.Errorf("No key stored for tiled log %d", logID)
+		}
+		tiledKeyPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: logObj.PublicKey})
+	}
+
+	// Logs are stored without their scheme
+	source, err := openLogSource(fmt.Sprintf("https://%s", logObj.URL), tiledKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	sources[logID] = source
+	return source, nil
+}
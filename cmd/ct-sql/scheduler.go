@@ -0,0 +1,176 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// LogPoll is what a download pass saw of a log
+type LogPoll struct {
+	TreeSize  uint64    // Size of the log's tree head
+	Timestamp uint64    // Timestamp of the tree head, in milliseconds
+	MaxEntry  uint64    // How far we've committed after the pass
+	Polled    time.Time // When the tree head was fetched
+}
+
+// The log growth we'd like each poll to pick up, about one get-entries batch
+const pollTargetEntries = 1024
+
+type scheduledLog struct {
+	url        string
+	source     LogSource
+	next       time.Time
+	running    bool
+	last       *LogPoll
+	growthRate float64 // Entries per second, smoothed over polls
+	backlog    uint64  // Entries in the log we haven't yet committed
+}
+
+// observe updates the log's growth rate and backlog from a download pass.
+func (sl *scheduledLog) observe(poll *LogPoll) {
+	if sl.last != nil && poll.Timestamp > sl.last.Timestamp && poll.TreeSize >= sl.last.TreeSize {
+		elapsed := float64(poll.Timestamp-sl.last.Timestamp) / 1000
+		rate := float64(poll.TreeSize-sl.last.TreeSize) / elapsed
+		if sl.growthRate == 0 {
+			sl.growthRate = rate
+		} else {
+			sl.growthRate = (sl.growthRate + rate) / 2
+		}
+	}
+	sl.last = poll
+
+	sl.backlog = 0
+	if poll.TreeSize > poll.MaxEntry {
+		sl.backlog = poll.TreeSize - poll.MaxEntry
+	}
+}
+
+// interval is how long to wait before polling the log again. Logs we're
+// behind on are polled as soon as permitted; otherwise we wait about as long
+// as the log takes to grow by pollTargetEntries.
+func (sl *scheduledLog) interval(minDelay, maxDelay time.Duration) time.Duration {
+	if sl.backlog > 0 {
+		return minDelay
+	}
+	if sl.growthRate <= 0 {
+		return maxDelay
+	}
+
+	delay := time.Duration(pollTargetEntries / sl.growthRate * float64(time.Second))
+	switch {
+	case delay < minDelay:
+		return minDelay
+	case delay > maxDelay:
+		return maxDelay
+	}
+	return delay
+}
+
+// byBacklog orders logs furthest behind first
+type byBacklog []*scheduledLog
+
+func (b byBacklog) Len() int           { return len(b) }
+func (b byBacklog) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byBacklog) Less(i, j int) bool { return b[i].backlog > b[j].backlog }
+
+type pollResult struct {
+	log  *scheduledLog
+	poll *LogPoll
+}
+
+// Scheduler polls logs forever, each at an interval derived from how fast it
+// grows and how far behind we are, downloading at most maxConcurrent at once.
+type Scheduler struct {
+	Downloader    *LogDownloader
+	MinDelay      time.Duration
+	MaxDelay      time.Duration
+	MaxConcurrent int
+	logs          []*scheduledLog
+}
+
+func NewScheduler(ld *LogDownloader, minDelay, maxDelay time.Duration, maxConcurrent int) *Scheduler {
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	return &Scheduler{
+		Downloader:    ld,
+		MinDelay:      minDelay,
+		MaxDelay:      maxDelay,
+		MaxConcurrent: maxConcurrent,
+	}
+}
+
+// Add schedules the log for an immediate first poll.
+func (s *Scheduler) Add(ctLogUrl string, source LogSource) {
+	s.logs = append(s.logs, &scheduledLog{url: ctLogUrl, source: source, next: time.Now()})
+}
+
+// due returns the logs waiting to be polled, furthest behind first, and how
+// long until the next one not yet due.
+func (s *Scheduler) due(now time.Time) ([]*scheduledLog, time.Duration) {
+	var ready []*scheduledLog
+	wait := s.MaxDelay
+	for _, sl := range s.logs {
+		if sl.running {
+			continue
+		}
+		if !sl.next.After(now) {
+			ready = append(ready, sl)
+		} else if until := sl.next.Sub(now); until < wait {
+			wait = until
+		}
+	}
+	sort.Stable(byBacklog(ready))
+	return ready, wait
+}
+
+// Run polls until ctx is done, then waits for running downloads.
+func (s *Scheduler) Run(ctx context.Context) {
+	results := make(chan pollResult)
+	running := 0
+
+	for {
+		ready, wait := s.due(time.Now())
+		for _, sl := range ready {
+			if ctx.Err() != nil || (s.MaxConcurrent > 0 && running >= s.MaxConcurrent) {
+				break
+			}
+			sl.running = true
+			running++
+			s.Downloader.DownloaderWaitGroup.Add(1)
+			go func(sl *scheduledLog) {
+				defer s.Downloader.DownloaderWaitGroup.Done()
+				results <- pollResult{sl, s.Downloader.Download(ctx, sl.url, sl.source)}
+			}(sl)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping. Waiting for %d downloads.\n", running)
+			for ; running > 0; running-- {
+				<-results
+			}
+			return
+		case result := <-results:
+			running--
+			sl := result.log
+			sl.running = false
+
+			delay := s.MaxDelay
+			if result.poll != nil {
+				sl.observe(result.poll)
+				delay = sl.interval(s.MinDelay, s.MaxDelay)
+			}
+			sl.next = time.Now().Add(delay)
+			log.Printf("[%s] Completed, %d behind. Polling again in %s.\n", sl.url, sl.backlog, delay)
+		case <-time.After(wait):
+		}
+	}
+}
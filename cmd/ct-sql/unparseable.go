@@ -0,0 +1,49 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+
+	"github.com/jcjones/ct-sql/sqldb"
+	"golang.org/x/net/context"
+)
+
+// reprocessUnparseable inserts each entry in the unparseable_entry table that
+// the lenient parser can make sense of.
+func reprocessUnparseable(ctx context.Context, db *sqldb.EntriesDatabase) error {
+	entries, err := db.GetUnparseableEntries(*config.Limit)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Reprocessing %d unparseable entries", len(entries))
+
+	var inserted, outside int
+	for i := range entries {
+		if ctx.Err() != nil {
+			log.Printf("Stopping after %d unparseable entries", i)
+			break
+		}
+		entry := &entries[i]
+
+		err = db.ReprocessUnparseableEntry(ctx, entry)
+		if err != nil {
+			if *config.Verbose {
+				log.Printf("Entry %d/%d still unparseable: %s", entry.LogID, entry.EntryID, err)
+			}
+			continue
+		}
+		if entry.CertID == 0 {
+			outside++
+			continue
+		}
+		inserted++
+	}
+
+	log.Printf("Inserted %d of %d unparseable entries, and found %d outside their log's shard",
+		inserted, len(entries), outside)
+	return nil
+}
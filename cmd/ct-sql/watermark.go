@@ -0,0 +1,85 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"sync"
+)
+
+// Watermark tracks the highest contiguous log index that the insert workers
+// have committed. Workers finish entries out of order, so anything committed
+// past a gap is held in pending until the gap closes.
+type Watermark struct {
+	next     uint64            // Every index below this has been committed
+	marked   uint64            // Value of next at the last call to Advance
+	lastTime uint64            // Leaf timestamp of the entry at next-1
+	pending  map[uint64]uint64 // Committed past the watermark: index -> leaf timestamp
+	cond     *sync.Cond
+}
+
+func NewWatermark(start uint64) *Watermark {
+	return &Watermark{
+		next:    start,
+		marked:  start,
+		pending: make(map[uint64]uint64),
+		cond:    sync.NewCond(new(sync.Mutex)),
+	}
+}
+
+// Ack records that the entry at index has been committed. A zero timestamp
+// (for an entry that couldn't be decoded) leaves the last entry time alone.
+func (wm *Watermark) Ack(index uint64, timestamp uint64) {
+	wm.cond.L.Lock()
+	defer wm.cond.L.Unlock()
+
+	if index < wm.next {
+		return
+	}
+	wm.pending[index] = timestamp
+
+	advanced := false
+	for {
+		ts, ok := wm.pending[wm.next]
+		if !ok {
+			break
+		}
+		delete(wm.pending, wm.next)
+		if ts != 0 {
+			wm.lastTime = ts
+		}
+		wm.next++
+		advanced = true
+	}
+
+	if advanced {
+		wm.cond.Broadcast()
+	}
+}
+
+// Committed returns the index of the first uncommitted entry, along with the
+// leaf timestamp of the entry just before it (zero if nothing was committed).
+func (wm *Watermark) Committed() (uint64, uint64) {
+	wm.cond.L.Lock()
+	defer wm.cond.L.Unlock()
+	return wm.next, wm.lastTime
+}
+
+// Advance returns the range [from, to) committed since the previous call.
+func (wm *Watermark) Advance() (uint64, uint64) {
+	wm.cond.L.Lock()
+	defer wm.cond.L.Unlock()
+	from := wm.marked
+	wm.marked = wm.next
+	return from, wm.next
+}
+
+// WaitFor blocks until every entry below index has been committed.
+func (wm *Watermark) WaitFor(index uint64) {
+	wm.cond.L.Lock()
+	defer wm.cond.L.Unlock()
+	for wm.next < index {
+		wm.cond.Wait()
+	}
+}
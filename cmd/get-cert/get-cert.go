@@ -28,7 +28,7 @@ func main() {
 
 	certFolderDB, err := utils.NewFolderDatabase(*config.CertPath, 0444, *config.CertsPerFolder)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, fmt.Sprintf("unable to open Certificate Path: %s: %s", config.CertPath, err))
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("unable to open Certificate Path: %s: %s", *config.CertPath, err))
 		os.Exit(1)
 		return
 	}
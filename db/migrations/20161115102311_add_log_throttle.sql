@@ -0,0 +1,17 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `logthrottle` (
+  `logID` int(11) NOT NULL,
+  `time` datetime NOT NULL,
+  `statusCode` smallint unsigned NOT NULL,
+  `retryAfter` int unsigned NOT NULL DEFAULT 0,
+  KEY `LogTimeIdx` (`logID`, `time`),
+  CONSTRAINT `logthrottle-logID` FOREIGN KEY (`logID`) REFERENCES `ctlog` (`logID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `logthrottle`;
@@ -0,0 +1,25 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `failed_entry` (
+  `failureID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `source` varchar(16) NOT NULL,
+  `logID` int(11) NOT NULL DEFAULT 0,
+  `entryID` bigint(20) unsigned NOT NULL,
+  `entryTime` datetime DEFAULT NULL,
+  `leafInput` mediumblob,
+  `extraData` mediumblob,
+  `errorClass` varchar(16) NOT NULL,
+  `error` text,
+  `attempts` int unsigned NOT NULL DEFAULT 1,
+  `lastAttempt` datetime DEFAULT NULL,
+  PRIMARY KEY (`failureID`),
+  UNIQUE KEY `entry` (`source`, `logID`, `entryID`),
+  KEY `lastAttemptIdx` (`lastAttempt`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `failed_entry`;
@@ -0,0 +1,17 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `ctlogcoverage` (
+  `logID` int(11) NOT NULL,
+  `startEntry` bigint(20) unsigned NOT NULL,
+  `endEntry` bigint(20) unsigned NOT NULL,
+  UNIQUE KEY `logStart` (`logID`, `startEntry`),
+  KEY `logEnd` (`logID`, `endEntry`),
+  CONSTRAINT `ctlogcoverage-logID` FOREIGN KEY (`logID`) REFERENCES `ctlog` (`logID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `ctlogcoverage`;
@@ -0,0 +1,32 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE `ctlog`
+  ADD COLUMN `publicKey` varbinary(1024) NOT NULL DEFAULT '' AFTER `lastEntryTime`,
+  ADD COLUMN `keyID` char(44) NOT NULL DEFAULT '' AFTER `publicKey`,
+  ADD KEY `keyIDIdx` (`keyID`);
+
+CREATE TABLE `sct` (
+  `certID` bigint(20) unsigned NOT NULL,
+  `keyID` char(44) NOT NULL,
+  `logID` int(11) NOT NULL DEFAULT 0,
+  `timestamp` datetime NOT NULL,
+  `signature` varbinary(1024) NOT NULL,
+  `verified` tinyint(1) DEFAULT NULL,
+  `version` tinyint unsigned NOT NULL DEFAULT 0,
+  UNIQUE KEY `certLogTime` (`certID`, `keyID`, `timestamp`),
+  KEY `logIDIdx` (`logID`),
+  KEY `keyIDIdx` (`keyID`),
+  CONSTRAINT `sct-certID` FOREIGN KEY (`certID`) REFERENCES `certificate` (`certID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `sct`;
+
+ALTER TABLE `ctlog`
+  DROP KEY `keyIDIdx`,
+  DROP COLUMN `keyID`,
+  DROP COLUMN `publicKey`;
@@ -0,0 +1,29 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE `ctlog`
+  ADD COLUMN `mmd` int(11) NOT NULL DEFAULT 86400 AFTER `keyID`;
+
+CREATE TABLE `mmd_violation` (
+  `logID` int(11) NOT NULL,
+  `certID` bigint(20) unsigned NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `sctTime` datetime NOT NULL,
+  `observedTime` datetime NOT NULL,
+  `entryID` bigint(20) unsigned DEFAULT NULL,
+  `delaySeconds` bigint(20) NOT NULL,
+  `checkedAt` datetime NOT NULL,
+  UNIQUE KEY `logCertTime` (`logID`, `certID`, `sctTime`, `kind`),
+  KEY `certIDIdx` (`certID`),
+  CONSTRAINT `mmd_violation-logID` FOREIGN KEY (`logID`) REFERENCES `ctlog` (`logID`) ON DELETE CASCADE,
+  CONSTRAINT `mmd_violation-certID` FOREIGN KEY (`certID`) REFERENCES `certificate` (`certID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `mmd_violation`;
+
+ALTER TABLE `ctlog`
+  DROP COLUMN `mmd`;
@@ -0,0 +1,28 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE `ctlog`
+  ADD COLUMN `operator` varchar(255) NOT NULL DEFAULT '' AFTER `mmd`;
+
+CREATE TABLE `ct_compliance` (
+  `certID` bigint(20) unsigned NOT NULL,
+  `issuerID` int(11) NOT NULL,
+  `compliant` tinyint(1) NOT NULL,
+  `scts` int(11) NOT NULL,
+  `operators` int(11) NOT NULL,
+  `requiredSCTs` int(11) NOT NULL,
+  `reason` varchar(255) NOT NULL DEFAULT '',
+  `checkedAt` datetime NOT NULL,
+  PRIMARY KEY (`certID`),
+  KEY `issuerCompliantIdx` (`issuerID`, `compliant`),
+  CONSTRAINT `ct_compliance-certID` FOREIGN KEY (`certID`) REFERENCES `certificate` (`certID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `ct_compliance`;
+
+ALTER TABLE `ctlog`
+  DROP COLUMN `operator`;
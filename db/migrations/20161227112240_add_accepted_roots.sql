@@ -0,0 +1,30 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `ctlog_root` (
+  `logID` int(11) NOT NULL,
+  `certID` bigint(20) unsigned NOT NULL,
+  `firstSeen` datetime NOT NULL,
+  UNIQUE KEY `logRoot` (`logID`, `certID`),
+  KEY `certIDIdx` (`certID`),
+  CONSTRAINT `ctlog_root-logID` FOREIGN KEY (`logID`) REFERENCES `ctlog` (`logID`) ON DELETE CASCADE,
+  CONSTRAINT `ctlog_root-certID` FOREIGN KEY (`certID`) REFERENCES `certificate` (`certID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `ctlog_root_change` (
+  `logID` int(11) NOT NULL,
+  `certID` bigint(20) unsigned NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `time` datetime NOT NULL,
+  KEY `logTimeIdx` (`logID`, `time`),
+  KEY `certIDIdx` (`certID`),
+  CONSTRAINT `ctlog_root_change-logID` FOREIGN KEY (`logID`) REFERENCES `ctlog` (`logID`) ON DELETE CASCADE,
+  CONSTRAINT `ctlog_root_change-certID` FOREIGN KEY (`certID`) REFERENCES `certificate` (`certID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `ctlog_root_change`;
+DROP TABLE `ctlog_root`;
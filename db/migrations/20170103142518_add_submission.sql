@@ -0,0 +1,22 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `submission` (
+  `certID` bigint(20) unsigned NOT NULL,
+  `logID` int(11) NOT NULL,
+  `submittedAt` datetime NOT NULL,
+  `outcome` varchar(16) NOT NULL,
+  `sctTime` datetime DEFAULT NULL,
+  `signature` varbinary(1024) DEFAULT NULL,
+  `error` text,
+  KEY `certLogIdx` (`certID`, `logID`, `outcome`),
+  KEY `logTimeIdx` (`logID`, `submittedAt`),
+  CONSTRAINT `submission-certID` FOREIGN KEY (`certID`) REFERENCES `certificate` (`certID`) ON DELETE CASCADE,
+  CONSTRAINT `submission-logID` FOREIGN KEY (`logID`) REFERENCES `ctlog` (`logID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `submission`;
@@ -0,0 +1,27 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `log_health` (
+  `logID` int(11) NOT NULL,
+  `operation` varchar(16) NOT NULL,
+  `periodStart` datetime NOT NULL,
+  `periodEnd` datetime NOT NULL,
+  `calls` int(11) NOT NULL,
+  `errors` int(11) NOT NULL,
+  `p50Ms` bigint(20) NOT NULL,
+  `p95Ms` bigint(20) NOT NULL,
+  `p99Ms` bigint(20) NOT NULL,
+  `maxMs` bigint(20) NOT NULL,
+  `lastError` text,
+  `sthTime` datetime DEFAULT NULL,
+  `sthAge` bigint(20) NOT NULL DEFAULT 0,
+  KEY `logPeriodIdx` (`logID`, `periodEnd`),
+  KEY `periodEndIdx` (`periodEnd`),
+  CONSTRAINT `log_health-logID` FOREIGN KEY (`logID`) REFERENCES `ctlog` (`logID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `log_health`;
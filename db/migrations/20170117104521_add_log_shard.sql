@@ -0,0 +1,14 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE `ctlog`
+  ADD COLUMN `shardStart` DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00' AFTER `operator`,
+  ADD COLUMN `shardEnd` DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00' AFTER `shardStart`;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE `ctlog`
+  DROP COLUMN `shardStart`,
+  DROP COLUMN `shardEnd`;
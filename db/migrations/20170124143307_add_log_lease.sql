@@ -0,0 +1,23 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `ctlog_lease` (
+  `logID` int(11) NOT NULL,
+  `startEntry` bigint(20) unsigned NOT NULL,
+  `endEntry` bigint(20) unsigned NOT NULL,
+  `committed` bigint(20) unsigned NOT NULL,
+  `lastEntryTime` datetime NOT NULL DEFAULT '0000-00-00 00:00:00',
+  `owner` varchar(255) NOT NULL,
+  `expires` datetime NOT NULL,
+  `completed` tinyint(1) NOT NULL DEFAULT 0,
+  `takeovers` int(11) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`logID`, `startEntry`),
+  KEY `logExpiresIdx` (`logID`, `completed`, `expires`),
+  CONSTRAINT `ctlog_lease-logID` FOREIGN KEY (`logID`) REFERENCES `ctlog` (`logID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `ctlog_lease`;
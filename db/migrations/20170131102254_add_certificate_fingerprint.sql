@@ -0,0 +1,15 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `certificate_fingerprint` (
+  `fingerprint` binary(32) NOT NULL,
+  `certID` bigint(20) unsigned NOT NULL,
+  PRIMARY KEY (`fingerprint`),
+  KEY `certIdx` (`certID`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `certificate_fingerprint`;
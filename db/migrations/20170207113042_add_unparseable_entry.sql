@@ -0,0 +1,23 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `unparseable_entry` (
+  `logID` int(11) NOT NULL,
+  `entryID` bigint(20) unsigned NOT NULL,
+  `entryTime` datetime NOT NULL DEFAULT '0000-00-00 00:00:00',
+  `entryType` smallint unsigned NOT NULL,
+  `der` mediumblob NOT NULL,
+  `chain` mediumblob,
+  `parseError` text,
+  `firstSeen` datetime NOT NULL DEFAULT '0000-00-00 00:00:00',
+  `certID` bigint(20) unsigned NOT NULL DEFAULT 0,
+  PRIMARY KEY (`logID`, `entryID`),
+  KEY `certIdx` (`certID`),
+  KEY `firstSeenIdx` (`firstSeen`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `unparseable_entry`;
@@ -0,0 +1,36 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `organization` (
+  `orgID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `country` varchar(64) NOT NULL DEFAULT '',
+  PRIMARY KEY (`orgID`),
+  UNIQUE KEY `nameCountryIdx` (`name`, `country`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `cert_subject` (
+  `certID` bigint(20) unsigned NOT NULL,
+  `orgID` bigint(20) unsigned NOT NULL DEFAULT 0,
+  `organizationalUnit` varchar(255) NOT NULL DEFAULT '',
+  `country` varchar(64) NOT NULL DEFAULT '',
+  `province` varchar(128) NOT NULL DEFAULT '',
+  `locality` varchar(128) NOT NULL DEFAULT '',
+  `serialNumber` varchar(64) NOT NULL DEFAULT '',
+  `businessCategory` varchar(128) NOT NULL DEFAULT '',
+  `jurisdictionCountry` varchar(64) NOT NULL DEFAULT '',
+  `jurisdictionProvince` varchar(128) NOT NULL DEFAULT '',
+  `jurisdictionLocality` varchar(128) NOT NULL DEFAULT '',
+  `validation` varchar(2) NOT NULL DEFAULT '',
+  PRIMARY KEY (`certID`),
+  KEY `orgIdx` (`orgID`),
+  KEY `countryIdx` (`country`),
+  KEY `validationIdx` (`validation`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `cert_subject`;
+DROP TABLE `organization`;
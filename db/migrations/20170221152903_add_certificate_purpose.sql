@@ -0,0 +1,14 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE `certificate`
+  ADD COLUMN `purpose` varchar(16) NOT NULL DEFAULT '' AFTER `notAfter`,
+  ADD KEY `purposeIdx` (`purpose`);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE `certificate`
+  DROP KEY `purposeIdx`,
+  DROP COLUMN `purpose`;
@@ -0,0 +1,30 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `name_constraint` (
+  `issuerID` int(11) NOT NULL,
+  `keyID` varchar(255) NOT NULL,
+  `type` varchar(8) NOT NULL,
+  `excluded` tinyint(1) NOT NULL,
+  `value` varchar(255) NOT NULL,
+  PRIMARY KEY (`issuerID`, `keyID`, `type`, `excluded`, `value`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `name_constraint_violation` (
+  `certID` bigint(20) unsigned NOT NULL,
+  `issuerID` int(11) NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `keyID` varchar(255) NOT NULL,
+  `excluded` tinyint(1) NOT NULL,
+  `value` text,
+  `checkedAt` datetime NOT NULL DEFAULT '0000-00-00 00:00:00',
+  PRIMARY KEY (`certID`, `name`),
+  KEY `issuerIdx` (`issuerID`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `name_constraint_violation`;
+DROP TABLE `name_constraint`;
@@ -0,0 +1,15 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `cert_spki` (
+  `certID` bigint(20) unsigned NOT NULL,
+  `spkiHash` binary(32) NOT NULL,
+  PRIMARY KEY (`certID`),
+  KEY `spkiHashIdx` (`spkiHash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `cert_spki`;
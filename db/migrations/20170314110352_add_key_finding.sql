@@ -0,0 +1,17 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `key_finding` (
+  `certID` bigint(20) unsigned NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `detail` text,
+  `checkedAt` datetime NOT NULL DEFAULT '0000-00-00 00:00:00',
+  PRIMARY KEY (`certID`, `kind`),
+  KEY `kindIdx` (`kind`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `key_finding`;
@@ -0,0 +1,12 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE `ctlog`
+  ADD COLUMN `tiled` tinyint(1) NOT NULL DEFAULT 0 AFTER `shardEnd`;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE `ctlog`
+  DROP COLUMN `tiled`;
@@ -0,0 +1,20 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE `ctlogentry`
+  ADD COLUMN `entryType` smallint unsigned DEFAULT NULL AFTER `entryTime`;
+
+ALTER TABLE `ct_compliance`
+  MODIFY COLUMN `compliant` tinyint(1) DEFAULT NULL;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DELETE FROM `ct_compliance` WHERE `compliant` IS NULL;
+
+ALTER TABLE `ct_compliance`
+  MODIFY COLUMN `compliant` tinyint(1) NOT NULL;
+
+ALTER TABLE `ctlogentry`
+  DROP COLUMN `entryType`;
@@ -0,0 +1,27 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `shard_violation` (
+  `logID` int(11) NOT NULL,
+  `entryID` bigint(20) unsigned NOT NULL,
+  `notAfter` datetime NOT NULL DEFAULT '0000-00-00 00:00:00',
+  `shardStart` datetime NOT NULL DEFAULT '0000-00-00 00:00:00',
+  `shardEnd` datetime NOT NULL DEFAULT '0000-00-00 00:00:00',
+  `seenAt` datetime NOT NULL,
+  PRIMARY KEY (`logID`, `entryID`),
+  CONSTRAINT `shard_violation-logID` FOREIGN KEY (`logID`) REFERENCES `ctlog` (`logID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- Out-of-shard entries used to be kept as failures, and retried; their
+-- notAfter wasn't kept, so it stays unset
+INSERT IGNORE INTO `shard_violation` (logID, entryID, shardStart, shardEnd, seenAt)
+  SELECT f.logID, f.entryID, l.shardStart, l.shardEnd, COALESCE(f.lastAttempt, NOW())
+  FROM `failed_entry` AS f JOIN `ctlog` AS l ON l.logID = f.logID
+  WHERE f.errorClass = 'shard';
+DELETE FROM `failed_entry` WHERE errorClass = 'shard';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `shard_violation`;
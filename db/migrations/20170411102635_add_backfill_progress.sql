@@ -0,0 +1,15 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE `backfill_progress` (
+  `kind` varchar(32) NOT NULL,
+  `lastCertID` bigint(20) unsigned NOT NULL DEFAULT 0,
+  `updatedAt` datetime NOT NULL,
+  PRIMARY KEY (`kind`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE `backfill_progress`;
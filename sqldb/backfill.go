@@ -0,0 +1,42 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Tracks how far passes filling in data for existing certificates have got,
+// so runs limited to some number of certificates each pick up where the last
+// left off, rather than trying the same unreadable ones again
+
+package sqldb
+
+import (
+	"time"
+)
+
+// Kinds of backfill
+const (
+	BackfillSPKI         = "spki"        // cert_spki, from the stored certificates
+	BackfillFingerprints = "fingerprint" // certificate_fingerprint, from the stored certificates
+)
+
+type BackfillProgress struct {
+	Kind       string    `db:"kind"`       // One of the Backfill constants
+	LastCertID uint64    `db:"lastCertID"` // Every certificate up to this one has been tried
+	UpdatedAt  time.Time `db:"updatedAt"`  // Date of the last run
+}
+
+// getBackfillProgress returns the last certID the backfill tried, zero if it
+// never ran.
+func (edb *EntriesDatabase) getBackfillProgress(kind string) (uint64, error) {
+	lastCertID, err := edb.DbMap.SelectInt("SELECT COALESCE(MAX(lastCertID), 0) FROM backfill_progress WHERE kind = ?", kind)
+	return uint64(lastCertID), err
+}
+
+// SaveBackfillProgress records that the backfill tried every certificate up
+// to lastCertID. Certificates inserted since are filled in as they are, so
+// the next run carries on from there.
+func (edb *EntriesDatabase) SaveBackfillProgress(kind string, lastCertID uint64) error {
+	_, err := edb.DbMap.Exec(`INSERT INTO backfill_progress (kind, lastCertID, updatedAt) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE lastCertID = VALUES(lastCertID), updatedAt = VALUES(updatedAt)`,
+		kind, lastCertID, time.Now())
+	return err
+}
@@ -0,0 +1,171 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Keeps the chains certificates were logged with, so they can be submitted
+// to other logs
+
+package sqldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/google/certificate-transparency/go"
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// StoredChain is the chain a log entry was logged with. For precertificates
+// it starts with the precertificate itself, as in the entry's extra_data.
+type StoredChain struct {
+	EntryType ct.LogEntryType
+	Chain     []ct.ASN1Cert
+}
+
+func NewStoredChain(entry *ct.LogEntry) *StoredChain {
+	return &StoredChain{
+		EntryType: entry.Leaf.TimestampedEntry.EntryType,
+		Chain:     entry.Chain,
+	}
+}
+
+// RFC 6962 Section 3.1, the extended key usage marking a Precertificate
+// Signing Certificate
+var oidExtKeyUsagePrecertSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 4}
+
+func isPrecertSigningCert(cert *x509.Certificate) bool {
+	for _, usage := range cert.UnknownExtKeyUsage {
+		if usage.Equal(oidExtKeyUsagePrecertSigning) {
+			return true
+		}
+	}
+	return false
+}
+
+// Issuer returns the certificate that issued the entry, if the chain has it.
+// For precertificates signed by a Precertificate Signing Certificate, that's
+// the CA that issued the signing certificate.
+func (sc *StoredChain) Issuer() *x509.Certificate {
+	if sc == nil {
+		return nil
+	}
+	issuerIndex := 0
+	if sc.EntryType == ct.PrecertLogEntryType {
+		issuerIndex = 1
+		if sc.PrecertSigner() != nil {
+			issuerIndex = 2
+		}
+	}
+	if len(sc.Chain) <= issuerIndex {
+		return nil
+	}
+	issuer, err := x509.ParseCertificate(sc.Chain[issuerIndex])
+	if err != nil {
+		return nil
+	}
+	return issuer
+}
+
+// PrecertSigner returns the Precertificate Signing Certificate that signed
+// the precertificate, or nil if it was signed by its CA directly.
+func (sc *StoredChain) PrecertSigner() *x509.Certificate {
+	if sc == nil || sc.EntryType != ct.PrecertLogEntryType || len(sc.Chain) < 2 {
+		return nil
+	}
+	signer, err := x509.ParseCertificate(sc.Chain[1])
+	if err != nil || !isPrecertSigningCert(signer) {
+		return nil
+	}
+	return signer
+}
+
+// issuerIdentity returns the authority key ID and common name of the CA that
+// issued the certificate. A precertificate signed by a Precertificate Signing
+// Certificate may name that signer as its issuer, rather than the CA the final
+// certificate will name, so then the signer's own issuer is used.
+func issuerIdentity(cert *x509.Certificate, chain *StoredChain) ([]byte, string) {
+	signer := chain.PrecertSigner()
+	if signer == nil {
+		return cert.AuthorityKeyId, cert.Issuer.CommonName
+	}
+
+	namesSigner := bytes.Equal(cert.RawIssuer, signer.RawSubject)
+	if len(cert.AuthorityKeyId) > 0 && len(signer.SubjectKeyId) > 0 {
+		namesSigner = bytes.Equal(cert.AuthorityKeyId, signer.SubjectKeyId)
+	}
+	if !namesSigner {
+		// The log already rewrote the issuer, as RFC 6962 asks
+		return cert.AuthorityKeyId, cert.Issuer.CommonName
+	}
+	return signer.AuthorityKeyId, signer.Issuer.CommonName
+}
+
+// Encoded as the uint16 entry type, then each certificate with a uint24
+// length, as in RFC 6962
+func (sc *StoredChain) Bytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(sc.EntryType))
+	for _, cert := range sc.Chain {
+		buf.Write([]byte{byte(len(cert) >> 16), byte(len(cert) >> 8), byte(len(cert))})
+		buf.Write(cert)
+	}
+	return buf.Bytes()
+}
+
+func ParseStoredChain(data []byte) (*StoredChain, error) {
+	reader := bytes.NewReader(data)
+	var entryType uint16
+	if err := binary.Read(reader, binary.BigEndian, &entryType); err != nil {
+		return nil, err
+	}
+
+	sc := &StoredChain{EntryType: ct.LogEntryType(entryType)}
+	for reader.Len() > 0 {
+		length := make([]byte, 3)
+		if _, err := io.ReadFull(reader, length); err != nil {
+			return nil, err
+		}
+		cert := make([]byte, int(length[0])<<16|int(length[1])<<8|int(length[2]))
+		if _, err := io.ReadFull(reader, cert); err != nil {
+			return nil, fmt.Errorf("truncated chain: %s", err)
+		}
+		sc.Chain = append(sc.Chain, cert)
+	}
+	return sc, nil
+}
+
+// GetStoredCertificate reads back the DER certificate (the TBS, for
+// precertificates) and chain stored for the certificate.
+func (edb *EntriesDatabase) GetStoredCertificate(certID uint64) ([]byte, *StoredChain, error) {
+	if edb.FullCerts == nil {
+		return nil, nil, fmt.Errorf("No certificate folder configured")
+	}
+	der, err := edb.FullCerts.Get(certID)
+	if err != nil {
+		return nil, nil, err
+	}
+	chainData, err := edb.FullCerts.GetChain(certID)
+	if err != nil {
+		return der, nil, err
+	}
+	chain, err := ParseStoredChain(chainData)
+	return der, chain, err
+}
+
+// storedCertificate parses the stored certificate leniently, as checks over
+// every certificate would otherwise skip those beyond x509.
+func (edb *EntriesDatabase) storedCertificate(certID uint64) (*x509.Certificate, error) {
+	der, err := edb.FullCerts.Get(certID)
+	if err != nil {
+		return nil, err
+	}
+	// Precertificates are stored as their TBS
+	cert, err := ParseCertificateLeniently(der, false)
+	if err != nil {
+		return ParseCertificateLeniently(der, true)
+	}
+	return cert, nil
+}
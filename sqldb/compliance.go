@@ -0,0 +1,223 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Evaluates certificates against a CT policy, such as those browsers enforce,
+// using their embedded SCTs and the logs holding their final certificates
+
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/certificate-transparency/go"
+)
+
+// PolicyTier requires RequiredSCTs for certificates whose lifetime is at
+// most MaxLifetime. A zero MaxLifetime matches any lifetime.
+type PolicyTier struct {
+	MaxLifetime  time.Duration
+	RequiredSCTs int
+}
+
+type CTPolicy struct {
+	Tiers             []PolicyTier // In ascending order of MaxLifetime, the last usually unbounded
+	DistinctOperators int          // SCTs must come from at least this many log operators
+}
+
+// ParseCTPolicy reads tiers written as "days:scts" pairs, comma delimited,
+// ending with a bare SCT count for any longer lifetime; e.g. "180:2,3" needs
+// two SCTs for certificates of up to 180 days, and three beyond.
+func ParseCTPolicy(tiers string, distinctOperators int) (*CTPolicy, error) {
+	policy := &CTPolicy{DistinctOperators: distinctOperators}
+	for _, part := range strings.Split(tiers, ",") {
+		var tier PolicyTier
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		switch len(fields) {
+		case 1:
+		case 2:
+			days, err := strconv.Atoi(fields[0])
+			if err != nil || days <= 0 {
+				return nil, fmt.Errorf("Bad lifetime in policy tier %q", part)
+			}
+			tier.MaxLifetime = time.Duration(days) * 24 * time.Hour
+		default:
+			return nil, fmt.Errorf("Bad policy tier %q", part)
+		}
+
+		scts, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil || scts < 0 {
+			return nil, fmt.Errorf("Bad SCT count in policy tier %q", part)
+		}
+		tier.RequiredSCTs = scts
+		policy.Tiers = append(policy.Tiers, tier)
+	}
+	return policy, nil
+}
+
+// RequiredSCTs returns how many SCTs a certificate of the given lifetime
+// needs, or -1 if no tier applies.
+func (p *CTPolicy) RequiredSCTs(lifetime time.Duration) int {
+	for _, tier := range p.Tiers {
+		if tier.MaxLifetime == 0 || lifetime <= tier.MaxLifetime {
+			return tier.RequiredSCTs
+		}
+	}
+	return -1
+}
+
+type CertCompliance struct {
+	CertID       uint64       `db:"certID, primarykey"` // Internal Cert Identifier (FK to Certificate)
+	IssuerID     int          `db:"issuerID"`           // The Issuer of this cert
+	Compliant    sql.NullBool `db:"compliant"`          // Whether the cert meets the policy, null if unknown
+	SCTs         int          `db:"scts"`               // SCTs from distinct known logs
+	Operators    int          `db:"operators"`          // Distinct operators of those logs
+	RequiredSCTs int          `db:"requiredSCTs"`       // SCTs the policy wanted for the cert's lifetime
+	Reason       string       `db:"reason"`             // Why the cert is not compliant, or couldn't be evaluated
+	CheckedAt    time.Time    `db:"checkedAt"`          // Date when this check was run
+}
+
+type IssuerCompliance struct {
+	IssuerID     int    `db:"issuerID"`     // Internal Issuer ID
+	CommonName   string `db:"commonName"`   // Issuer CN
+	Certificates int    `db:"certificates"` // Certificates evaluated
+	NonCompliant int    `db:"nonCompliant"` // Of those, how many failed the policy
+	Unknown      int    `db:"unknown"`      // Certificates that couldn't be evaluated
+}
+
+// Reason given for certificates that aren't evaluated
+const complianceUnknownReason = "no embedded SCTs or logged final certificate"
+
+// Evaluate classifies a certificate given the operators of the logs its
+// SCTs came from, one per SCT.
+func (p *CTPolicy) Evaluate(notBefore, notAfter time.Time, operators []string) (bool, int, int, string) {
+	required := p.RequiredSCTs(notAfter.Sub(notBefore))
+
+	distinct := make(map[string]struct{})
+	for _, operator := range operators {
+		distinct[operator] = struct{}{}
+	}
+
+	switch {
+	case required < 0:
+		return false, required, len(distinct), "lifetime exceeds every policy tier"
+	case len(operators) < required:
+		return false, required, len(distinct), fmt.Sprintf("%d of %d SCTs", len(operators), required)
+	case len(distinct) < p.DistinctOperators:
+		return false, required, len(distinct), fmt.Sprintf("%d of %d operators", len(distinct), p.DistinctOperators)
+	}
+	return true, required, len(distinct), ""
+}
+
+// EvaluateCompliance classifies every unexpired TLS server certificate under
+// the policy, storing the results. Only SCTs from known logs that didn't fail
+// verification count, along with the logs holding the final certificate,
+// which issued it an SCT to be delivered by TLS or OCSP. Certificates with no
+// embedded SCTs that weren't logged as final certificates, such as those only seen as precertificates or in
+// Censys, may have had their SCTs delivered another way, so they're recorded
+// as unknown, as are certificates for other purposes. Returns the number of
+// certificates evaluated, and the number unknown.
+func (edb *EntriesDatabase) EvaluateCompliance(policy *CTPolicy) (int, int, error) {
+	// Logs without a recorded operator are taken to be their own operator.
+	// Certificates stored before purposes were kept have none.
+	rows, err := edb.DbMap.Db.Query(`SELECT c.certID, c.issuerID, c.notBefore, c.notAfter,
+			c.purpose IN (?, '') AND (
+				EXISTS (SELECT 1 FROM sct AS e WHERE e.certID = c.certID) OR
+				EXISTS (SELECT 1 FROM ctlogentry AS e WHERE e.certID = c.certID AND e.entryType = ?)),
+			s.logID, IF(l.operator = '', CONCAT('log:', l.logID), l.operator)
+		FROM unexpired_certificate AS u
+		JOIN certificate AS c ON c.certID = u.certID
+		LEFT JOIN (SELECT certID, logID FROM sct
+				WHERE logID != 0 AND (verified IS NULL OR verified = 1)
+			UNION SELECT certID, logID FROM ctlogentry
+				WHERE entryType = ?) AS s ON s.certID = c.certID
+		LEFT JOIN ctlog AS l ON l.logID = s.logID
+		ORDER BY c.certID`, PurposeTLSServer, int(ct.X509LogEntryType), int(ct.X509LogEntryType))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var current *CertCompliance
+	var notBefore, notAfter time.Time
+	var evaluable bool
+	var operators []string
+	var count, unknown int
+
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if evaluable {
+			current.Compliant.Valid = true
+			current.Compliant.Bool, current.RequiredSCTs, current.Operators, current.Reason =
+				policy.Evaluate(notBefore, notAfter, operators)
+			count++
+		} else {
+			current.RequiredSCTs = policy.RequiredSCTs(notAfter.Sub(notBefore))
+			current.Reason = complianceUnknownReason
+			unknown++
+		}
+		current.SCTs = len(operators)
+
+		_, err := edb.DbMap.Exec(`INSERT INTO ct_compliance
+			(certID, issuerID, compliant, scts, operators, requiredSCTs, reason, checkedAt)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+				compliant = VALUES(compliant), scts = VALUES(scts), operators = VALUES(operators),
+				requiredSCTs = VALUES(requiredSCTs), reason = VALUES(reason), checkedAt = VALUES(checkedAt)`,
+			current.CertID, current.IssuerID, current.Compliant, current.SCTs, current.Operators,
+			current.RequiredSCTs, current.Reason, current.CheckedAt)
+		return err
+	}
+
+	for rows.Next() {
+		var certID uint64
+		var issuerID int
+		var certNotBefore, certNotAfter time.Time
+		var certEvaluable bool
+		var logID sql.NullInt64
+		var operator sql.NullString
+		err = rows.Scan(&certID, &issuerID, &certNotBefore, &certNotAfter, &certEvaluable, &logID, &operator)
+		if err != nil {
+			return count, unknown, err
+		}
+
+		if current == nil || current.CertID != certID {
+			if err = finish(); err != nil {
+				return count, unknown, err
+			}
+			current = &CertCompliance{CertID: certID, IssuerID: issuerID, CheckedAt: now}
+			notBefore, notAfter = certNotBefore, certNotAfter
+			evaluable = certEvaluable
+			operators = operators[:0]
+		}
+		if logID.Valid && operator.Valid {
+			operators = append(operators, operator.String)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return count, unknown, err
+	}
+	err = finish()
+	return count, unknown, err
+}
+
+// GetIssuerCompliance counts the evaluated, non-compliant and unknown
+// certificates of each issuer, worst first.
+func (edb *EntriesDatabase) GetIssuerCompliance() ([]IssuerCompliance, error) {
+	var counts []IssuerCompliance
+	_, err := edb.DbMap.Select(&counts, `SELECT c.issuerID, i.commonName,
+			COUNT(c.compliant) AS certificates, IFNULL(SUM(c.compliant = 0), 0) AS nonCompliant,
+			SUM(c.compliant IS NULL) AS unknown
+		FROM ct_compliance AS c
+		JOIN issuer AS i ON i.issuerID = c.issuerID
+		GROUP BY c.issuerID, i.commonName
+		ORDER BY nonCompliant DESC`)
+	return counts, err
+}
@@ -0,0 +1,320 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Records the name constraints of the CA certificates in logged chains, and
+// checks the names of the certificates they issued against them
+
+package sqldb
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/certificate-transparency/go"
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// Kinds of name constraint
+const (
+	ConstraintDNS   = "dns"
+	ConstraintIP    = "ip"
+	ConstraintEmail = "email"
+)
+
+var oidExtensionNameConstraints = asn1.ObjectIdentifier{2, 5, 29, 30}
+
+type NameConstraint struct {
+	IssuerID int    `db:"issuerID"` // The Issuer whose certificates are constrained
+	KeyID    string `db:"keyID"`    // Base64 subject key ID of the CA certificate in the chain imposing it
+	Type     string `db:"type"`     // One of the Constraint constants
+	Excluded bool   `db:"excluded"` // Whether names matching are excluded, rather than permitted
+	Value    string `db:"value"`    // Domain, mailbox or domain, or CIDR network
+}
+
+type NameConstraintViolation struct {
+	CertID     uint64    `db:"certID"`    // Internal Cert Identifier (FK to Certificate)
+	IssuerID   int       `db:"issuerID"`  // Internal Issuer ID
+	Name       string    `db:"name"`      // The name violating the constraint
+	KeyID      string    `db:"keyID"`     // Base64 subject key ID of the CA certificate imposing it
+	Excluded   bool      `db:"excluded"`  // Whether the name is excluded, rather than not permitted
+	Constraint string    `db:"value"`     // The excluded value, or the permitted values, comma delimited
+	CheckedAt  time.Time `db:"checkedAt"` // Date when this check was run
+}
+
+type nameConstraintsExt struct {
+	Permitted []asn1.RawValue `asn1:"optional,tag:0"`
+	Excluded  []asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// certNameConstraints returns the name constraints the CA certificate
+// imposes, of the kinds we check. Undecodable subtrees are skipped.
+func certNameConstraints(cert *x509.Certificate) []NameConstraint {
+	var constraints []NameConstraint
+	keyID := base64.StdEncoding.EncodeToString(cert.SubjectKeyId)
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionNameConstraints) {
+			continue
+		}
+		var ncs nameConstraintsExt
+		if _, err := asn1.Unmarshal(ext.Value, &ncs); err != nil {
+			continue
+		}
+
+		add := func(subtrees []asn1.RawValue, excluded bool) {
+			for _, subtree := range subtrees {
+				// GeneralSubtree ::= SEQUENCE { base GeneralName, ... }
+				fields, err := lenientElements(subtree.FullBytes)
+				if err != nil || len(fields) == 0 {
+					continue
+				}
+				nc := NameConstraint{KeyID: keyID, Excluded: excluded}
+				base := fields[0]
+				switch base.Tag {
+				case 1:
+					nc.Type, nc.Value = ConstraintEmail, string(base.Bytes)
+				case 2:
+					nc.Type, nc.Value = ConstraintDNS, string(base.Bytes)
+				case 7:
+					// An address followed by its mask
+					half := len(base.Bytes) / 2
+					if half != net.IPv4len && half != net.IPv6len {
+						continue
+					}
+					network := net.IPNet{IP: net.IP(base.Bytes[:half]), Mask: net.IPMask(base.Bytes[half:])}
+					nc.Type, nc.Value = ConstraintIP, network.String()
+				default:
+					continue
+				}
+				constraints = append(constraints, nc)
+			}
+		}
+		add(ncs.Permitted, false)
+		add(ncs.Excluded, true)
+	}
+	return constraints
+}
+
+// chainNameConstraints returns the name constraints of every CA certificate
+// in the chain.
+func chainNameConstraints(chain *StoredChain) []NameConstraint {
+	if chain == nil {
+		return nil
+	}
+	start := 0
+	if chain.EntryType == ct.PrecertLogEntryType {
+		start = 1
+	}
+
+	var constraints []NameConstraint
+	for i := start; i < len(chain.Chain); i++ {
+		// Constrained CAs are often beyond x509, which rejects many critical
+		// name constraints
+		caCert, err := ParseCertificateLeniently(chain.Chain[i], false)
+		if err != nil {
+			continue
+		}
+		constraints = append(constraints, certNameConstraints(caCert)...)
+	}
+	return constraints
+}
+
+// recordNameConstraints stores the constraints the chain imposes on the
+// issuer's certificates, once per issuer each run.
+func (edb *EntriesDatabase) recordNameConstraints(issuerID int, chain *StoredChain) error {
+	edb.ConstraintsLock.RLock()
+	_, recorded := edb.KnownConstraints[issuerID]
+	edb.ConstraintsLock.RUnlock()
+	if recorded || chain == nil {
+		return nil
+	}
+
+	for _, nc := range chainNameConstraints(chain) {
+		_, err := edb.DbMap.Exec(`INSERT IGNORE INTO name_constraint
+			(issuerID, keyID, type, excluded, value) VALUES (?, ?, ?, ?, ?)`,
+			issuerID, nc.KeyID, nc.Type, nc.Excluded, nc.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	edb.ConstraintsLock.Lock()
+	if edb.KnownConstraints == nil {
+		edb.KnownConstraints = make(map[int]struct{})
+	}
+	edb.KnownConstraints[issuerID] = struct{}{}
+	edb.ConstraintsLock.Unlock()
+	return nil
+}
+
+// nameType returns the kind of constraint that applies to the name.
+func nameType(name string) string {
+	switch {
+	case net.ParseIP(name) != nil:
+		return ConstraintIP
+	case strings.Contains(name, "@"):
+		return ConstraintEmail
+	}
+	return ConstraintDNS
+}
+
+// matchDomain reports whether the domain is within the constraint. The "*"
+// label of a wildcard is kept, so it counts as a subdomain, as the names it
+// covers are.
+func matchDomain(domain, constraint string) bool {
+	domain = strings.ToLower(domain)
+	constraint = strings.ToLower(constraint)
+	switch {
+	case len(constraint) == 0:
+		return true
+	case strings.HasPrefix(constraint, "."):
+		return strings.HasSuffix(domain, constraint)
+	}
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
+
+// matchConstraint reports whether the name falls within the constraint, per
+// RFC 5280 Section 4.2.1.10.
+func matchConstraint(name string, nc *NameConstraint) bool {
+	switch nc.Type {
+	case ConstraintIP:
+		_, network, err := net.ParseCIDR(nc.Value)
+		return err == nil && network.Contains(net.ParseIP(name))
+	case ConstraintEmail:
+		at := strings.LastIndex(name, "@")
+		switch {
+		case strings.Contains(nc.Value, "@"):
+			return strings.EqualFold(name, nc.Value)
+		case strings.HasPrefix(nc.Value, "."):
+			return matchDomain(name[at+1:], nc.Value)
+		}
+		return strings.EqualFold(name[at+1:], nc.Value)
+	}
+	return matchDomain(name, nc.Value)
+}
+
+// violatedConstraint checks the name against each CA's constraints of its
+// kind: it must match one of a CA's permitted values, if it has any, and none
+// of the excluded.
+func violatedConstraint(name string, constraints []NameConstraint) *NameConstraintViolation {
+	kind := nameType(name)
+	permitted := make(map[string][]string)
+	matched := make(map[string]bool)
+
+	for i := range constraints {
+		nc := &constraints[i]
+		if nc.Type != kind {
+			continue
+		}
+		if nc.Excluded {
+			if matchConstraint(name, nc) {
+				return &NameConstraintViolation{Name: name, KeyID: nc.KeyID, Excluded: true, Constraint: nc.Value}
+			}
+			continue
+		}
+		permitted[nc.KeyID] = append(permitted[nc.KeyID], nc.Value)
+		matched[nc.KeyID] = matched[nc.KeyID] || matchConstraint(name, nc)
+	}
+
+	for keyID, values := range permitted {
+		if !matched[keyID] {
+			return &NameConstraintViolation{Name: name, KeyID: keyID, Constraint: strings.Join(values, ",")}
+		}
+	}
+	return nil
+}
+
+// certNames returns the names to check against name constraints: those in
+// the subjectAltName, or the CN if there are none.
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && len(cert.Subject.CommonName) > 0 {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// CheckNameConstraints checks the names of every certificate of each issuer
+// with name constraints, read from the stored certificates, replacing the
+// previously recorded violations. Returns the number of names checked and the
+// violations found.
+func (edb *EntriesDatabase) CheckNameConstraints() (int, []NameConstraintViolation, error) {
+	if edb.FullCerts == nil {
+		return 0, nil, fmt.Errorf("No certificate folder configured")
+	}
+
+	var all []NameConstraint
+	_, err := edb.DbMap.Select(&all, "SELECT * FROM name_constraint ORDER BY issuerID")
+	if err != nil {
+		return 0, nil, err
+	}
+	byIssuer := make(map[int][]NameConstraint)
+	for _, nc := range all {
+		byIssuer[nc.IssuerID] = append(byIssuer[nc.IssuerID], nc)
+	}
+
+	now := time.Now()
+	var checked int
+	var violations []NameConstraintViolation
+
+	for issuerID, constraints := range byIssuer {
+		var certIDs []uint64
+		_, err = edb.DbMap.Select(&certIDs, "SELECT certID FROM certificate WHERE issuerID = ? ORDER BY certID", issuerID)
+		if err != nil {
+			return checked, violations, err
+		}
+
+		var found []NameConstraintViolation
+		for _, certID := range certIDs {
+			cert, err := edb.storedCertificate(certID)
+			if err != nil {
+				if edb.Verbose {
+					log.Printf("Unable to read the names of certID %d: %s", certID, err)
+				}
+				continue
+			}
+			for _, name := range certNames(cert) {
+				checked++
+				if violation := violatedConstraint(name, constraints); violation != nil {
+					violation.CertID = certID
+					violation.IssuerID = issuerID
+					violation.CheckedAt = now
+					found = append(found, *violation)
+				}
+			}
+		}
+
+		txn, err := edb.DbMap.Begin()
+		if err != nil {
+			return checked, violations, err
+		}
+		_, err = txn.Exec("DELETE FROM name_constraint_violation WHERE issuerID = ?", issuerID)
+		if err != nil {
+			txn.Rollback()
+			return checked, violations, err
+		}
+		for i := range found {
+			err = txn.Insert(&found[i])
+			if errorIsNotDuplicate(err) {
+				txn.Rollback()
+				return checked, violations, err
+			}
+		}
+		if err = txn.Commit(); err != nil {
+			return checked, violations, fmt.Errorf("DB error on name constraint violations of issuer %d: %s", issuerID, err)
+		}
+		violations = append(violations, found...)
+	}
+	return checked, violations, nil
+}
@@ -0,0 +1,50 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package sqldb
+
+import (
+	"testing"
+)
+
+func TestMatchConstraint(t *testing.T) {
+	cases := []struct {
+		name       string
+		constraint NameConstraint
+		match      bool
+	}{
+		{"example.com", NameConstraint{Type: ConstraintDNS, Value: "example.com"}, true},
+		{"www.example.com", NameConstraint{Type: ConstraintDNS, Value: "example.com"}, true},
+		{"www.example.com", NameConstraint{Type: ConstraintDNS, Value: ".example.com"}, true},
+		{"example.com", NameConstraint{Type: ConstraintDNS, Value: ".example.com"}, false},
+		{"badexample.com", NameConstraint{Type: ConstraintDNS, Value: "example.com"}, false},
+		{"*.example.com", NameConstraint{Type: ConstraintDNS, Value: ".example.com"}, true},
+		{"*.example.com", NameConstraint{Type: ConstraintDNS, Value: "example.com"}, true},
+		{"*.example.com", NameConstraint{Type: ConstraintDNS, Value: ".www.example.com"}, false},
+		{"*.example.org", NameConstraint{Type: ConstraintDNS, Value: ".example.com"}, false},
+		{"user@example.com", NameConstraint{Type: ConstraintEmail, Value: "example.com"}, true},
+		{"user@mail.example.com", NameConstraint{Type: ConstraintEmail, Value: ".example.com"}, true},
+		{"user@example.com", NameConstraint{Type: ConstraintEmail, Value: "other@example.com"}, false},
+		{"10.1.2.3", NameConstraint{Type: ConstraintIP, Value: "10.0.0.0/8"}, true},
+		{"192.168.1.1", NameConstraint{Type: ConstraintIP, Value: "10.0.0.0/8"}, false},
+	}
+	for _, c := range cases {
+		if got := matchConstraint(c.name, &c.constraint); got != c.match {
+			t.Errorf("%s against %s: expected %t, got %t", c.name, c.constraint.Value, c.match, got)
+		}
+	}
+}
+
+func TestWildcardViolations(t *testing.T) {
+	permitted := []NameConstraint{{KeyID: "a", Type: ConstraintDNS, Value: ".example.com"}}
+	if violation := violatedConstraint("*.example.com", permitted); violation != nil {
+		t.Errorf("Expected *.example.com to be permitted by .example.com, got %+v", violation)
+	}
+
+	excluded := []NameConstraint{{KeyID: "a", Type: ConstraintDNS, Excluded: true, Value: ".example.com"}}
+	violation := violatedConstraint("*.example.com", excluded)
+	if violation == nil || !violation.Excluded {
+		t.Errorf("Expected *.example.com to be excluded by .example.com, got %+v", violation)
+	}
+}
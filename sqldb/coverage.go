@@ -0,0 +1,182 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Tracks which index ranges of each log have been examined, and finds the gaps
+
+package sqldb
+
+import (
+	"database/sql"
+	"sort"
+)
+
+type LogCoverage struct {
+	LogID      int    `db:"logID"`      // Log Identifier (FK to CertificateLog)
+	StartEntry uint64 `db:"startEntry"` // First index examined
+	EndEntry   uint64 `db:"endEntry"`   // One past the last index examined
+}
+
+// IndexRange is the half-open range of log indices [Start, End)
+type IndexRange struct {
+	Start uint64
+	End   uint64
+}
+
+func (r IndexRange) Len() uint64 {
+	return r.End - r.Start
+}
+
+// RecordCoverage notes that every entry in [start, end) of the log has been
+// examined, whether it was inserted, filtered out, or recorded as failed.
+// Adjacent ranges are merged, so each contiguous run stays a single row.
+// Entries recorded as failed are still reported by FindGaps until resolved.
+func (edb *EntriesDatabase) RecordCoverage(logID int, start, end uint64) error {
+	if end <= start {
+		return nil
+	}
+
+	res, err := edb.DbMap.Exec("UPDATE ctlogcoverage SET endEntry = ? WHERE logID = ? AND endEntry = ?", end, logID, start)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows > 0 {
+		return nil
+	}
+
+	_, err = edb.DbMap.Exec(`INSERT INTO ctlogcoverage (logID, startEntry, endEntry) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE endEntry = GREATEST(endEntry, VALUES(endEntry))`, logID, start, end)
+	return err
+}
+
+type entryHole struct {
+	GapStart uint64        `db:"gapStart"` // First missing index
+	GapEnd   sql.NullInt64 `db:"gapEnd"`   // Next index present, if any
+}
+
+// FindGaps returns the ranges below upTo that appear in neither ctlogentry
+// nor ctlogcoverage. Entries that were filtered out never reach ctlogentry,
+// so only ranges missing from both are really missing. Entries in
+// failed_entry were examined but never stored, so they count as missing.
+func (edb *EntriesDatabase) FindGaps(logID int, upTo uint64) ([]IndexRange, error) {
+	var holes []entryHole
+
+	// The start of each hole is an entry whose successor is missing; the hole
+	// runs until the next entry present.
+	_, err := edb.DbMap.Select(&holes, `SELECT a.entryID + 1 AS gapStart,
+			(SELECT MIN(b.entryID) FROM ctlogentry AS b
+				WHERE b.logID = a.logID AND b.entryID > a.entryID) AS gapEnd
+		FROM ctlogentry AS a
+		WHERE a.logID = ? AND NOT EXISTS
+			(SELECT 1 FROM ctlogentry AS c WHERE c.logID = a.logID AND c.entryID = a.entryID + 1)`,
+		logID)
+	if err != nil {
+		return nil, err
+	}
+
+	var gaps []IndexRange
+
+	firstEntry, err := edb.DbMap.SelectNullInt("SELECT MIN(entryID) FROM ctlogentry WHERE logID = ?", logID)
+	if err != nil {
+		return nil, err
+	}
+	if !firstEntry.Valid {
+		gaps = append(gaps, IndexRange{0, upTo})
+	} else if firstEntry.Int64 > 0 {
+		gaps = append(gaps, IndexRange{0, uint64(firstEntry.Int64)})
+	}
+
+	for _, hole := range holes {
+		end := upTo
+		if hole.GapEnd.Valid {
+			end = uint64(hole.GapEnd.Int64)
+		}
+		gaps = append(gaps, IndexRange{hole.GapStart, end})
+	}
+
+	var coverage []LogCoverage
+	_, err = edb.DbMap.Select(&coverage, "SELECT * FROM ctlogcoverage WHERE logID = ?", logID)
+	if err != nil {
+		return nil, err
+	}
+
+	covered := make([]IndexRange, len(coverage))
+	for i, c := range coverage {
+		covered[i] = IndexRange{c.StartEntry, c.EndEntry}
+	}
+
+	failed, err := edb.failedRanges(logID)
+	if err != nil {
+		return nil, err
+	}
+
+	return subtractRanges(clampRanges(gaps, upTo), subtractRanges(covered, failed)), nil
+}
+
+// failedRanges returns the log's unresolved failed entries, with consecutive
+// indices merged into ranges.
+func (edb *EntriesDatabase) failedRanges(logID int) ([]IndexRange, error) {
+	var entryIDs []uint64
+	_, err := edb.DbMap.Select(&entryIDs, "SELECT entryID FROM failed_entry WHERE source = ? AND logID = ? ORDER BY entryID",
+		FailureSourceCT, logID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []IndexRange
+	for _, entryID := range entryIDs {
+		if last := len(ranges) - 1; last >= 0 && ranges[last].End == entryID {
+			ranges[last].End++
+			continue
+		}
+		ranges = append(ranges, IndexRange{entryID, entryID + 1})
+	}
+	return ranges, nil
+}
+
+type rangesByStart []IndexRange
+
+func (r rangesByStart) Len() int           { return len(r) }
+func (r rangesByStart) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r rangesByStart) Less(i, j int) bool { return r[i].Start < r[j].Start }
+
+// clampRanges drops anything at or beyond upTo.
+func clampRanges(ranges []IndexRange, upTo uint64) []IndexRange {
+	var clamped []IndexRange
+	for _, r := range ranges {
+		if r.End > upTo {
+			r.End = upTo
+		}
+		if r.Start < r.End {
+			clamped = append(clamped, r)
+		}
+	}
+	return clamped
+}
+
+// subtractRanges removes every index in holes from ranges, returning the
+// remainder in ascending order.
+func subtractRanges(ranges []IndexRange, holes []IndexRange) []IndexRange {
+	sort.Sort(rangesByStart(ranges))
+	sort.Sort(rangesByStart(holes))
+
+	var result []IndexRange
+	for _, r := range ranges {
+		for _, h := range holes {
+			if h.End <= r.Start || h.Start >= r.End {
+				continue
+			}
+			if h.Start > r.Start {
+				result = append(result, IndexRange{r.Start, h.Start})
+			}
+			r.Start = h.End
+			if r.Start >= r.End {
+				break
+			}
+		}
+		if r.Start < r.End {
+			result = append(result, r)
+		}
+	}
+	return result
+}
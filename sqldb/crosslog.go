@@ -0,0 +1,112 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Reports on which logs hold which certificates, from the entries recorded
+// with correlateLogEntries
+
+package sqldb
+
+import (
+	"fmt"
+	"time"
+)
+
+// IssuerLogSpread counts an issuer's certificates issued in a period by how
+// many logs hold them
+type IssuerLogSpread struct {
+	Period       string `db:"period" json:"period"`             // First day (YYYY-MM-DD) of the period of notBefore
+	IssuerID     int    `db:"issuerID" json:"issuerID"`         // Internal Issuer ID
+	CommonName   string `db:"commonName" json:"commonName"`     // Issuer CN
+	Certificates int    `db:"certificates" json:"certificates"` // Certificates seen in any log
+	OneLog       int    `db:"oneLog" json:"oneLog"`             // Of those, in exactly one log
+	TwoLogs      int    `db:"twoLogs" json:"twoLogs"`           // In exactly two logs
+	ThreeOrMore  int    `db:"threeOrMore" json:"threeOrMore"`   // In three or more logs
+}
+
+// LogUniqueCount counts the certificates a log holds that no other log does
+type LogUniqueCount struct {
+	LogID        int    `db:"logID" json:"logID"`               // Log Identifier (FK to CertificateLog)
+	URL          string `db:"url" json:"url"`                   // URL to the log
+	Certificates int    `db:"certificates" json:"certificates"` // Certificates only in this log
+}
+
+// LogOverlap counts the certificates held by both of a pair of logs; where
+// the IDs are equal, it's the number held by that log.
+type LogOverlap struct {
+	LogA         int `db:"logA" json:"logA"`                 // Log Identifier (FK to CertificateLog)
+	LogB         int `db:"logB" json:"logB"`                 // Log Identifier (FK to CertificateLog)
+	Certificates int `db:"certificates" json:"certificates"` // Certificates in both logs
+}
+
+// CrossLogReport is the coverage of the logs over a window of issuance
+type CrossLogReport struct {
+	Since    time.Time         `json:"since"`    // Earliest notBefore considered
+	Until    time.Time         `json:"until"`    // notBefore before which certificates are considered
+	Period   string            `json:"period"`   // Period by which Spread is broken down, week or month
+	Spread   []IssuerLogSpread `json:"spread"`   // By period, then issuer with most certificates first
+	Unique   []LogUniqueCount  `json:"unique"`   // By log, most unique certificates first
+	Overlaps []LogOverlap      `json:"overlaps"` // Every pair of logs sharing certificates
+}
+
+// periodStart is the SQL for the first day of the period holding notBefore;
+// weeks start on Monday.
+var periodStart = map[string]string{
+	"week":  "DATE_FORMAT(DATE_SUB(c.notBefore, INTERVAL WEEKDAY(c.notBefore) DAY), '%Y-%m-%d')",
+	"month": "DATE_FORMAT(c.notBefore, '%Y-%m-01')",
+}
+
+// GetCrossLogReport reports on the certificates issued (by notBefore) in
+// [since, until) that were found in any log, with the spread across logs
+// broken down by period, "week" or "month", so trends show.
+func (edb *EntriesDatabase) GetCrossLogReport(since, until time.Time, period string) (*CrossLogReport, error) {
+	periodSQL, ok := periodStart[period]
+	if !ok {
+		return nil, fmt.Errorf("Unknown report period: %s", period)
+	}
+	report := &CrossLogReport{Since: since, Until: until, Period: period}
+
+	// Logs holding each certificate in the window
+	perCert := `SELECT e.certID, MIN(e.logID) AS logID, COUNT(DISTINCT e.logID) AS logs
+		FROM ctlogentry AS e
+		JOIN certificate AS c ON c.certID = e.certID
+		WHERE c.notBefore >= ? AND c.notBefore < ?
+		GROUP BY e.certID`
+
+	_, err := edb.DbMap.Select(&report.Spread, `SELECT `+periodSQL+` AS period,
+			c.issuerID, i.commonName,
+			COUNT(*) AS certificates, SUM(p.logs = 1) AS oneLog,
+			SUM(p.logs = 2) AS twoLogs, SUM(p.logs >= 3) AS threeOrMore
+		FROM (`+perCert+`) AS p
+		JOIN certificate AS c ON c.certID = p.certID
+		JOIN issuer AS i ON i.issuerID = c.issuerID
+		GROUP BY period, c.issuerID, i.commonName
+		ORDER BY period, certificates DESC`, since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = edb.DbMap.Select(&report.Unique, `SELECT p.logID, l.url, COUNT(*) AS certificates
+		FROM (`+perCert+`) AS p
+		JOIN ctlog AS l ON l.logID = p.logID
+		WHERE p.logs = 1
+		GROUP BY p.logID, l.url
+		ORDER BY certificates DESC`, since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = edb.DbMap.Select(&report.Overlaps, `SELECT a.logID AS logA, b.logID AS logB,
+			COUNT(DISTINCT a.certID) AS certificates
+		FROM ctlogentry AS a
+		JOIN ctlogentry AS b ON b.certID = a.certID AND b.logID >= a.logID
+		JOIN certificate AS c ON c.certID = a.certID
+		WHERE c.notBefore >= ? AND c.notBefore < ?
+		GROUP BY a.logID, b.logID
+		ORDER BY a.logID, b.logID`, since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
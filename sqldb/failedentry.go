@@ -0,0 +1,146 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Keeps entries that could not be inserted, so they can be retried later
+
+package sqldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jcjones/ct-sql/censysdata"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+// Sources of failed entries
+const (
+	FailureSourceCT     = "ct"
+	FailureSourceCensys = "censys"
+)
+
+// Error classes for failed entries
+const (
+	FailureClassLeaf  = "leaf"  // The log entry itself could not be decoded
+	FailureClassParse = "parse" // The Censys certificate could not be parsed
+	FailureClassDB    = "db"    // The database rejected the certificate
+)
+
+type FailedEntry struct {
+	FailureID   uint64    `db:"failureID, primarykey, autoincrement"` // Internal Failure Identifier
+	Source      string    `db:"source"`                               // FailureSourceCT or FailureSourceCensys
+	LogID       int       `db:"logID"`                                // Log Identifier (FK to CertificateLog), zero for Censys
+	EntryID     uint64    `db:"entryID"`                              // Index within the log, or byte offset within the Censys dump
+	EntryTime   time.Time `db:"entryTime"`                            // Leaf timestamp, or Censys validation timestamp
+	LeafInput   []byte    `db:"leafInput"`                            // Raw leaf_input, or the DER certificate for Censys
+	ExtraData   []byte    `db:"extraData"`                            // Raw extra_data, empty for Censys
+	ErrorClass  string    `db:"errorClass"`                           // One of the FailureClass constants
+	Error       string    `db:"error"`                                // The most recent error
+	Attempts    int       `db:"attempts"`                             // Number of times this entry has failed
+	LastAttempt time.Time `db:"lastAttempt"`                          // Date of the most recent failure
+}
+
+// ParseError marks a certificate that could not be parsed, as opposed to one
+// the database refused.
+type ParseError struct {
+	Err error
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("unable to parse certificate: %s", e.Err)
+}
+
+// LeafError marks a log entry whose leaf_input or extra_data could not be
+// decoded.
+type LeafError struct {
+	Err error
+}
+
+func (e LeafError) Error() string {
+	return fmt.Sprintf("unable to decode log entry: %s", e.Err)
+}
+
+func ClassifyFailure(err error) string {
+	switch err.(type) {
+	case ParseError, *ParseError:
+		return FailureClassParse
+	case LeafError, *LeafError:
+		return FailureClassLeaf
+	}
+	return FailureClassDB
+}
+
+func (edb *EntriesDatabase) recordFailedEntry(obj *FailedEntry) error {
+	_, err := edb.DbMap.Exec(`INSERT INTO failed_entry
+		(source, logID, entryID, entryTime, leafInput, extraData, errorClass, error, attempts, lastAttempt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?)
+		ON DUPLICATE KEY UPDATE
+			attempts = attempts + 1, errorClass = VALUES(errorClass),
+			error = VALUES(error), lastAttempt = VALUES(lastAttempt)`,
+		obj.Source, obj.LogID, obj.EntryID, obj.EntryTime, obj.LeafInput, obj.ExtraData,
+		obj.ErrorClass, obj.Error, obj.LastAttempt)
+	return err
+}
+
+// RecordFailedCTEntry saves a log entry that could not be inserted, along
+// with its raw bytes. Repeat failures of the same entry bump its attempts.
+func (edb *EntriesDatabase) RecordFailedCTEntry(logID int, index uint64, timestamp uint64, leafInput []byte, extraData []byte, failure error) error {
+	return edb.recordFailedEntry(&FailedEntry{
+		Source:      FailureSourceCT,
+		LogID:       logID,
+		EntryID:     index,
+		EntryTime:   utils.Uint64ToTimestamp(timestamp),
+		LeafInput:   leafInput,
+		ExtraData:   extraData,
+		ErrorClass:  ClassifyFailure(failure),
+		Error:       failure.Error(),
+		LastAttempt: time.Now(),
+	})
+}
+
+// RecordFailedCensysEntry saves a Censys entry that could not be inserted.
+func (edb *EntriesDatabase) RecordFailedCensysEntry(entry *censysdata.CensysEntry, failure error) error {
+	obj := &FailedEntry{
+		Source:      FailureSourceCensys,
+		EntryID:     entry.Offset,
+		LeafInput:   entry.CertBytes,
+		ErrorClass:  ClassifyFailure(failure),
+		Error:       failure.Error(),
+		LastAttempt: time.Now(),
+	}
+	if entry.Timestamp != nil {
+		obj.EntryTime = *entry.Timestamp
+	}
+	return edb.recordFailedEntry(obj)
+}
+
+// GetFailedEntries returns failed entries, oldest failures first. A limit of
+// zero returns them all.
+func (edb *EntriesDatabase) GetFailedEntries(limit uint64) ([]FailedEntry, error) {
+	var entries []FailedEntry
+	query := "SELECT * FROM failed_entry ORDER BY lastAttempt"
+	var err error
+	if limit > 0 {
+		_, err = edb.DbMap.Select(&entries, query+" LIMIT ?", limit)
+	} else {
+		_, err = edb.DbMap.Select(&entries, query)
+	}
+	return entries, err
+}
+
+// ResolveFailedEntry removes an entry that has since been inserted.
+func (edb *EntriesDatabase) ResolveFailedEntry(obj *FailedEntry) error {
+	_, err := edb.DbMap.Delete(obj)
+	return err
+}
+
+// UpdateFailedEntry records another failed attempt on an entry.
+func (edb *EntriesDatabase) UpdateFailedEntry(obj *FailedEntry, failure error) error {
+	obj.Attempts++
+	obj.ErrorClass = ClassifyFailure(failure)
+	obj.Error = failure.Error()
+	obj.LastAttempt = time.Now()
+	_, err := edb.DbMap.Update(obj)
+	return err
+}
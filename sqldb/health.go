@@ -0,0 +1,45 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Records how each log has been responding to us
+
+package sqldb
+
+import (
+	"time"
+)
+
+// Operations whose health is tracked
+const (
+	HealthGetSTH     = "get-sth"
+	HealthGetEntries = "get-entries"
+)
+
+type LogHealth struct {
+	LogID       int       `db:"logID"`       // Log Identifier (FK to CertificateLog)
+	Operation   string    `db:"operation"`   // HealthGetSTH or HealthGetEntries
+	PeriodStart time.Time `db:"periodStart"` // Date of the first call summarized
+	PeriodEnd   time.Time `db:"periodEnd"`   // Date the summary was taken
+	Calls       int       `db:"calls"`       // Calls made, successful or not
+	Errors      int       `db:"errors"`      // Calls that failed
+	P50Ms       int64     `db:"p50Ms"`       // Median latency in milliseconds
+	P95Ms       int64     `db:"p95Ms"`       // 95th percentile latency in milliseconds
+	P99Ms       int64     `db:"p99Ms"`       // 99th percentile latency in milliseconds
+	MaxMs       int64     `db:"maxMs"`       // Slowest call in milliseconds
+	LastError   string    `db:"lastError"`   // The most recent error, if any
+	STHTime     time.Time `db:"sthTime"`     // Timestamp of the newest tree head seen from the log
+	STHAge      int64     `db:"sthAge"`      // Seconds between STHTime and PeriodEnd
+}
+
+func (edb *EntriesDatabase) InsertLogHealth(obj *LogHealth) error {
+	return edb.DbMap.Insert(obj)
+}
+
+// GetLogHealthSince returns the health summaries taken since the given time,
+// oldest first.
+func (edb *EntriesDatabase) GetLogHealthSince(since time.Time) ([]LogHealth, error) {
+	var summaries []LogHealth
+	_, err := edb.DbMap.Select(&summaries, "SELECT * FROM log_health WHERE periodEnd >= ? ORDER BY periodEnd", since)
+	return summaries, err
+}
@@ -0,0 +1,147 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Looks for weak RSA keys among the unexpired certificates
+
+package sqldb
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+// Kinds of key finding
+const (
+	KeyFindingDebian       = "debian"        // On a Debian weak key blocklist
+	KeyFindingROCA         = "roca"          // Matches the ROCA fingerprint
+	KeyFindingExponent     = "exponent"      // Public exponent is small or even
+	KeyFindingSharedFactor = "shared-factor" // Modulus shares a prime with another
+)
+
+// Exponents below this are reported
+const minPublicExponent = 65537
+
+type KeyFinding struct {
+	CertID    uint64    `db:"certID"`    // Internal Cert Identifier (FK to Certificate)
+	Kind      string    `db:"kind"`      // One of the KeyFinding constants
+	Detail    string    `db:"detail"`    // Such as the exponent, or the shared factor
+	CheckedAt time.Time `db:"checkedAt"` // Date when this check was run
+}
+
+type KeyReport struct {
+	Checked  int            // Unexpired certificates with stored RSA keys
+	Moduli   int            // Distinct moduli among them
+	Findings map[string]int // Certificates found, by kind
+}
+
+// storedRSAKey reads the RSA key of the stored certificate, or nil if it has
+// some other kind of key.
+func (edb *EntriesDatabase) storedRSAKey(certID uint64) (*rsa.PublicKey, error) {
+	cert, err := edb.storedCertificate(certID)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(cert.RawSubjectPublicKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := pub.(*rsa.PublicKey)
+	return key, nil
+}
+
+// CheckKeys checks the RSA key of every unexpired certificate, read from the
+// stored certificates, against the Debian blocklists, the ROCA fingerprint,
+// the exponent rules and, by batch GCD over groups of at most groupSize
+// moduli, each other, replacing previously recorded findings.
+func (edb *EntriesDatabase) CheckKeys(debianKeys utils.DebianWeakKeys, groupSize int) (*KeyReport, error) {
+	if edb.FullCerts == nil {
+		return nil, fmt.Errorf("No certificate folder configured")
+	}
+
+	var certIDs []uint64
+	_, err := edb.DbMap.Select(&certIDs, "SELECT certID FROM unexpired_certificate ORDER BY certID")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := &KeyReport{Findings: make(map[string]int)}
+	var findings []*KeyFinding
+	found := func(certID uint64, kind, detail string) {
+		findings = append(findings, &KeyFinding{CertID: certID, Kind: kind, Detail: detail, CheckedAt: now})
+		report.Findings[kind]++
+	}
+
+	// Each distinct modulus, and the certificates using it
+	var moduli []*big.Int
+	certsByModulus := make(map[string][]uint64)
+
+	for _, certID := range certIDs {
+		key, err := edb.storedRSAKey(certID)
+		if err != nil {
+			if edb.Verbose {
+				log.Printf("Unable to read the key of certID %d: %s", certID, err)
+			}
+			continue
+		}
+		if key == nil {
+			continue
+		}
+		report.Checked++
+
+		if debianKeys.Contains(key.N) {
+			found(certID, KeyFindingDebian, fmt.Sprintf("%d bits", key.N.BitLen()))
+		}
+		if utils.IsROCAVulnerable(key.N) {
+			found(certID, KeyFindingROCA, fmt.Sprintf("%d bits", key.N.BitLen()))
+		}
+		if key.E < minPublicExponent || key.E%2 == 0 {
+			found(certID, KeyFindingExponent, fmt.Sprintf("e=%d", key.E))
+		}
+
+		modulus := string(key.N.Bytes())
+		if _, ok := certsByModulus[modulus]; !ok {
+			moduli = append(moduli, key.N)
+		}
+		certsByModulus[modulus] = append(certsByModulus[modulus], certID)
+	}
+	report.Moduli = len(moduli)
+
+	one := big.NewInt(1)
+	for i, gcd := range utils.GroupedBatchGCD(moduli, groupSize) {
+		if gcd.Cmp(one) == 0 {
+			continue
+		}
+		detail := fmt.Sprintf("factor %x", gcd)
+		if gcd.Cmp(moduli[i]) == 0 {
+			detail = "both factors"
+		}
+		for _, certID := range certsByModulus[string(moduli[i].Bytes())] {
+			found(certID, KeyFindingSharedFactor, detail)
+		}
+	}
+
+	txn, err := edb.DbMap.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = txn.Exec("DELETE FROM key_finding"); err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+	for _, finding := range findings {
+		err = txn.Insert(finding)
+		if errorIsNotDuplicate(err) {
+			txn.Rollback()
+			return nil, err
+		}
+	}
+	return report, txn.Commit()
+}
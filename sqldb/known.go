@@ -0,0 +1,133 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Recognizes certificates that were already inserted, so that seeing them
+// again in another log or dump is cheap
+
+package sqldb
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+
+	"github.com/go-gorp/gorp"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+type CertFingerprint struct {
+	Fingerprint []byte `db:"fingerprint"` // SHA-256 of the DER certificate, or of the TBS for precertificates
+	CertID      uint64 `db:"certID"`      // Internal Cert Identifier (FK to Certificate)
+}
+
+func certFingerprint(cert *x509.Certificate) []byte {
+	digest := sha256.Sum256(cert.Raw)
+	return digest[:]
+}
+
+// LoadKnownCertificates fills a filter of expected size with the fingerprints
+// of every certificate already inserted, enabling the known-certificate fast
+// path. Certificates inserted before fingerprints were kept are only covered
+// once backfilled. Returns the number loaded.
+func (edb *EntriesDatabase) LoadKnownCertificates(expected uint64) (uint64, error) {
+	filter := utils.NewBloomFilter(expected)
+
+	rows, err := edb.DbMap.Db.Query("SELECT fingerprint FROM certificate_fingerprint")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count uint64
+	for rows.Next() {
+		var fingerprint []byte
+		if err = rows.Scan(&fingerprint); err != nil {
+			return count, err
+		}
+		filter.Add(fingerprint)
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return count, err
+	}
+
+	edb.KnownCerts = filter
+	return count, nil
+}
+
+// GetCertsWithoutFingerprint returns the certificates inserted before
+// fingerprints were kept, after the last one the backfill tried. A limit of
+// zero returns them all.
+func (edb *EntriesDatabase) GetCertsWithoutFingerprint(limit uint64) ([]uint64, error) {
+	lastCertID, err := edb.getBackfillProgress(BackfillFingerprints)
+	if err != nil {
+		return nil, err
+	}
+
+	var certIDs []uint64
+	query := `SELECT c.certID FROM certificate AS c
+		LEFT JOIN certificate_fingerprint AS f ON f.certID = c.certID
+		WHERE f.certID IS NULL AND c.certID > ? ORDER BY c.certID`
+	if limit > 0 {
+		_, err = edb.DbMap.Select(&certIDs, query+" LIMIT ?", lastCertID, limit)
+	} else {
+		_, err = edb.DbMap.Select(&certIDs, query, lastCertID)
+	}
+	return certIDs, err
+}
+
+// BackfillFingerprint records the fingerprint of the certificate, read from
+// the stored certificates, which hold the same DER (or TBS) it's taken over.
+// Returns false if it couldn't be read, which a database error doesn't count
+// as.
+func (edb *EntriesDatabase) BackfillFingerprint(certID uint64) (bool, error) {
+	if edb.FullCerts == nil {
+		return false, fmt.Errorf("No certificate folder configured")
+	}
+	der, err := edb.FullCerts.Get(certID)
+	if err != nil {
+		if edb.Verbose {
+			log.Printf("Unable to read certID %d: %s", certID, err)
+		}
+		return false, nil
+	}
+	digest := sha256.Sum256(der)
+	err = edb.DbMap.Insert(&CertFingerprint{Fingerprint: digest[:], CertID: certID})
+	if errorIsNotDuplicate(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// findKnownCertificate returns the certID of the certificate if it was
+// already inserted, or zero. Without a filter loaded, every certificate is
+// taken to be new.
+func (edb *EntriesDatabase) findKnownCertificate(fingerprint []byte) (uint64, error) {
+	if edb.KnownCerts == nil || !edb.KnownCerts.MayContain(fingerprint) {
+		return 0, nil
+	}
+
+	var known []CertFingerprint
+	_, err := edb.DbMap.Select(&known, "SELECT * FROM certificate_fingerprint WHERE fingerprint = ?", fingerprint)
+	if err != nil || len(known) == 0 {
+		return 0, err
+	}
+	return known[0].CertID, nil
+}
+
+func (edb *EntriesDatabase) insertFingerprint(txn *gorp.Transaction, certID uint64, fingerprint []byte) error {
+	err := txn.Insert(&CertFingerprint{Fingerprint: fingerprint, CertID: certID})
+	if errorIsNotDuplicate(err) {
+		return err
+	}
+	return nil
+}
+
+// rememberCertificate adds a committed certificate to the filter.
+func (edb *EntriesDatabase) rememberCertificate(fingerprint []byte) {
+	if edb.KnownCerts != nil {
+		edb.KnownCerts.Add(fingerprint)
+	}
+}
@@ -0,0 +1,202 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Leases on index ranges of a log, so several processes can download the
+// same log without overlapping or racing on its maxEntry
+
+package sqldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+// ErrLeaseLost is returned when a lease expired and was taken over by
+// another process.
+var ErrLeaseLost = fmt.Errorf("Lease was taken over by another process")
+
+type LogLease struct {
+	LogID         int       `db:"logID"`         // Log Identifier (FK to CertificateLog)
+	StartEntry    uint64    `db:"startEntry"`    // First index leased
+	EndEntry      uint64    `db:"endEntry"`      // One past the last index leased
+	Committed     uint64    `db:"committed"`     // Every index below this has been committed
+	LastEntryTime time.Time `db:"lastEntryTime"` // Leaf timestamp of the entry at Committed-1
+	Owner         string    `db:"owner"`         // Process holding the lease
+	Expires       time.Time `db:"expires"`       // Date after which another process may take over
+	Completed     bool      `db:"completed"`     // Whether the whole range has been committed
+	Takeovers     int       `db:"takeovers"`     // Times the lease expired and was taken over
+}
+
+// leaseSeconds is the duration as whole seconds for the database, which
+// computes every expiry with its own clock so processes on other hosts agree.
+func leaseSeconds(duration time.Duration) int64 {
+	return int64((duration + time.Second - 1) / time.Second)
+}
+
+// ClaimLease leases a range of the log to owner: an expired lease if there is
+// one, so it's finished where it left off, or else up to size entries past
+// everything leased so far, stopping short of treeSize. Returns nil if there
+// is nothing left to lease.
+func (edb *EntriesDatabase) ClaimLease(logID int, owner string, treeSize, size uint64, duration time.Duration) (*LogLease, error) {
+	txn, err := edb.DbMap.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	// Claims on the same log are serialized on its ctlog row
+	maxEntry, err := txn.SelectInt("SELECT maxEntry FROM ctlog WHERE logID = ? FOR UPDATE", logID)
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+
+	var expired []LogLease
+	_, err = txn.Select(&expired, `SELECT * FROM ctlog_lease
+		WHERE logID = ? AND completed = 0 AND expires < NOW() ORDER BY startEntry LIMIT 1`, logID)
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+	if len(expired) > 0 {
+		lease := &expired[0]
+		_, err = txn.Exec(`UPDATE ctlog_lease
+			SET owner = ?, expires = DATE_ADD(NOW(), INTERVAL ? SECOND), takeovers = takeovers + 1
+			WHERE logID = ? AND startEntry = ?`,
+			owner, leaseSeconds(duration), lease.LogID, lease.StartEntry)
+		if err != nil {
+			txn.Rollback()
+			return nil, err
+		}
+		return reloadLease(txn, lease)
+	}
+
+	leased, err := txn.SelectInt("SELECT COALESCE(MAX(endEntry), 0) FROM ctlog_lease WHERE logID = ?", logID)
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+
+	start := uint64(maxEntry)
+	if uint64(leased) > start {
+		start = uint64(leased)
+	}
+	if start >= treeSize {
+		return nil, txn.Commit()
+	}
+	end := start + size
+	if end > treeSize {
+		end = treeSize
+	}
+
+	_, err = txn.Exec(`INSERT INTO ctlog_lease (logID, startEntry, endEntry, committed, owner, expires)
+		VALUES (?, ?, ?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND))`,
+		logID, start, end, start, owner, leaseSeconds(duration))
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+	return reloadLease(txn, &LogLease{LogID: logID, StartEntry: start})
+}
+
+// reloadLease reads back the lease as the database stored it, with the
+// expiry it computed, and commits the claim.
+func reloadLease(txn *gorp.Transaction, lease *LogLease) (*LogLease, error) {
+	err := txn.SelectOne(lease, "SELECT * FROM ctlog_lease WHERE logID = ? AND startEntry = ?",
+		lease.LogID, lease.StartEntry)
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+	return lease, txn.Commit()
+}
+
+// RenewLease records the lease's progress and extends it, failing with
+// ErrLeaseLost if it's no longer held.
+func (edb *EntriesDatabase) RenewLease(lease *LogLease, committed, lastTime uint64, duration time.Duration) error {
+	if committed > lease.Committed {
+		lease.Committed = committed
+	}
+	if lastTime != 0 {
+		lease.LastEntryTime = utils.Uint64ToTimestamp(lastTime)
+	}
+
+	res, err := edb.DbMap.Exec(`UPDATE ctlog_lease
+		SET committed = ?, lastEntryTime = ?, expires = DATE_ADD(NOW(), INTERVAL ? SECOND)
+		WHERE logID = ? AND startEntry = ? AND owner = ?`,
+		lease.Committed, lease.LastEntryTime, leaseSeconds(duration), lease.LogID, lease.StartEntry, lease.Owner)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// CompleteLease marks the lease's range committed, then advances the log's
+// maxEntry across every completed lease contiguous with it, dropping those.
+// Returns the log's new maxEntry and lastEntryTime.
+func (edb *EntriesDatabase) CompleteLease(lease *LogLease) (uint64, time.Time, error) {
+	txn, err := edb.DbMap.Begin()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var certLogObj CertificateLog
+	err = txn.SelectOne(&certLogObj, "SELECT * FROM ctlog WHERE logID = ? FOR UPDATE", lease.LogID)
+	if err != nil {
+		txn.Rollback()
+		return 0, time.Time{}, err
+	}
+
+	res, err := txn.Exec(`UPDATE ctlog_lease SET completed = 1, committed = endEntry
+		WHERE logID = ? AND startEntry = ? AND owner = ?`, lease.LogID, lease.StartEntry, lease.Owner)
+	if err != nil {
+		txn.Rollback()
+		return 0, time.Time{}, err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		txn.Rollback()
+		return 0, time.Time{}, ErrLeaseLost
+	}
+	lease.Completed = true
+	lease.Committed = lease.EndEntry
+
+	var completed []LogLease
+	_, err = txn.Select(&completed, `SELECT * FROM ctlog_lease
+		WHERE logID = ? AND completed = 1 ORDER BY startEntry`, lease.LogID)
+	if err != nil {
+		txn.Rollback()
+		return 0, time.Time{}, err
+	}
+
+	maxEntry, lastEntryTime := certLogObj.MaxEntry, certLogObj.LastEntryTime
+	for _, done := range completed {
+		if done.StartEntry > maxEntry {
+			break
+		}
+		if done.EndEntry > maxEntry {
+			maxEntry = done.EndEntry
+		}
+		if done.LastEntryTime.After(lastEntryTime) {
+			lastEntryTime = done.LastEntryTime
+		}
+		_, err = txn.Exec("DELETE FROM ctlog_lease WHERE logID = ? AND startEntry = ?", done.LogID, done.StartEntry)
+		if err != nil {
+			txn.Rollback()
+			return 0, time.Time{}, err
+		}
+	}
+
+	_, err = txn.Exec("UPDATE ctlog SET maxEntry = ?, lastEntryTime = ? WHERE logID = ?",
+		maxEntry, lastEntryTime, lease.LogID)
+	if err != nil {
+		txn.Rollback()
+		return 0, time.Time{}, err
+	}
+	return maxEntry, lastEntryTime, txn.Commit()
+}
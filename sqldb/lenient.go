@@ -0,0 +1,272 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// A forgiving certificate parser, for entries x509 refuses outright
+
+package sqldb
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+)
+
+var (
+	oidExtensionSubjectKeyId      = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidExtensionSubjectAltName    = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtensionBasicConstraints  = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtensionAuthorityKeyId    = asn1.ObjectIdentifier{2, 5, 29, 35}
+	lenientUTCTimeLayouts         = []string{"060102150405Z0700", "0601021504Z0700", "060102150405"}
+	lenientGeneralizedTimeLayouts = []string{"20060102150405Z0700", "20060102150405.999999999Z0700", "20060102150405"}
+	errTruncatedCertificate       = fmt.Errorf("certificate has too few fields")
+)
+
+type lenientAttribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type lenientAuthorityKeyId struct {
+	Id []byte `asn1:"optional,tag:0"`
+}
+
+type lenientBasicConstraints struct {
+	IsCA bool `asn1:"optional"`
+}
+
+// ParseCertificateLeniently parses a certificate, or the TBSCertificate of a
+// precertificate. Certificates x509 only has non-fatal complaints about are
+// returned as x509 parsed them. Otherwise, the serial, names, validity and
+// the extensions we store are decoded one at a time, skipping what's broken,
+// so only a certificate missing its serial, issuer or validity is an error.
+func ParseCertificateLeniently(der []byte, isTBS bool) (*x509.Certificate, error) {
+	var cert *x509.Certificate
+	var err error
+	if isTBS {
+		cert, err = x509.ParseTBSCertificate(der)
+	} else {
+		cert, err = x509.ParseCertificate(der)
+	}
+	if err == nil || cert != nil {
+		return cert, nil
+	}
+
+	tbs := der
+	if !isTBS {
+		// Certificate ::= SEQUENCE { tbsCertificate, signatureAlgorithm, signatureValue }
+		outer, err := lenientElements(der)
+		if err != nil {
+			return nil, err
+		}
+		if len(outer) == 0 {
+			return nil, errTruncatedCertificate
+		}
+		tbs = outer[0].FullBytes
+	}
+
+	fields, err := lenientElements(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	cert = &x509.Certificate{
+		Raw:               der,
+		RawTBSCertificate: tbs,
+		Version:           1,
+	}
+
+	// The version is optional, and explicitly tagged [0]
+	if len(fields) > 0 && fields[0].Class == asn1.ClassContextSpecific && fields[0].Tag == 0 {
+		var version int
+		if _, err := asn1.Unmarshal(fields[0].Bytes, &version); err == nil {
+			cert.Version = version + 1
+		}
+		fields = fields[1:]
+	}
+
+	// serialNumber, signature, issuer, validity, subject, subjectPublicKeyInfo
+	if len(fields) < 6 {
+		return nil, errTruncatedCertificate
+	}
+
+	// Negative and non-minimally encoded serials are common reasons for
+	// rejection, so decode the two's complement by hand
+	serial := fields[0].Bytes
+	cert.SerialNumber = new(big.Int).SetBytes(serial)
+	if len(serial) > 0 && serial[0]&0x80 != 0 {
+		cert.SerialNumber.Sub(cert.SerialNumber, new(big.Int).Lsh(big.NewInt(1), uint(len(serial)*8)))
+	}
+
+	cert.RawIssuer = fields[2].FullBytes
+	cert.Issuer = lenientName(fields[2].FullBytes)
+
+	validity, err := lenientElements(fields[3].FullBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse validity: %s", err)
+	}
+	if len(validity) != 2 {
+		return nil, fmt.Errorf("validity has %d times", len(validity))
+	}
+	if cert.NotBefore, err = lenientTime(validity[0]); err != nil {
+		return nil, err
+	}
+	if cert.NotAfter, err = lenientTime(validity[1]); err != nil {
+		return nil, err
+	}
+
+	cert.RawSubject = fields[4].FullBytes
+	cert.Subject = lenientName(fields[4].FullBytes)
+
+	cert.RawSubjectPublicKeyInfo = fields[5].FullBytes
+	if pub, err := x509.ParsePKIXPublicKey(fields[5].FullBytes); err == nil {
+		cert.PublicKey = pub
+	}
+
+	// Then the optional issuerUniqueID [1], subjectUniqueID [2] and
+	// extensions [3]
+	for _, field := range fields[6:] {
+		if field.Class == asn1.ClassContextSpecific && field.Tag == 3 {
+			lenientExtensions(cert, field.Bytes)
+		}
+	}
+
+	return cert, nil
+}
+
+// lenientElements splits a SEQUENCE or SET into its elements, of whatever
+// types, ignoring anything after it.
+func lenientElements(der []byte) ([]asn1.RawValue, error) {
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &outer); err != nil {
+		return nil, err
+	}
+	if !outer.IsCompound {
+		return nil, fmt.Errorf("expected a sequence or set, got tag %d", outer.Tag)
+	}
+
+	var elements []asn1.RawValue
+	for rest := outer.Bytes; len(rest) > 0; {
+		var element asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &element); err != nil {
+			return elements, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+// lenientName decodes each attribute's value as a plain string, whatever its
+// declared type.
+func lenientName(raw []byte) pkix.Name {
+	var name pkix.Name
+	rdns, err := lenientElements(raw)
+	if err != nil {
+		return name
+	}
+
+	var seq pkix.RDNSequence
+	for _, rdn := range rdns {
+		attrs, err := lenientElements(rdn.FullBytes)
+		if err != nil {
+			continue
+		}
+		var set pkix.RelativeDistinguishedNameSET
+		for _, rawAttr := range attrs {
+			var attr lenientAttribute
+			if _, err := asn1.Unmarshal(rawAttr.FullBytes, &attr); err != nil {
+				continue
+			}
+			set = append(set, pkix.AttributeTypeAndValue{Type: attr.Type, Value: string(attr.Value.Bytes)})
+		}
+		seq = append(seq, set)
+	}
+	name.FillFromRDNSequence(&seq)
+	return name
+}
+
+// lenientTime accepts UTCTime and GeneralizedTime with or without seconds or
+// a timezone, which is taken to be UTC when absent.
+func lenientTime(raw asn1.RawValue) (time.Time, error) {
+	var layouts []string
+	switch raw.Tag {
+	case asn1.TagUTCTime:
+		layouts = lenientUTCTimeLayouts
+	case asn1.TagGeneralizedTime:
+		layouts = lenientGeneralizedTimeLayouts
+	default:
+		return time.Time{}, fmt.Errorf("unknown time type: %d", raw.Tag)
+	}
+
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, string(raw.Bytes)); err == nil {
+			if t.Year() >= 2050 && raw.Tag == asn1.TagUTCTime {
+				t = t.AddDate(-100, 0, 0)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse time: %q", raw.Bytes)
+}
+
+// lenientExtensions fills in the extensions we store, skipping any that
+// can't be decoded.
+func lenientExtensions(cert *x509.Certificate, raw []byte) {
+	exts, err := lenientElements(raw)
+	if err != nil {
+		return
+	}
+
+	for _, rawExt := range exts {
+		var ext pkix.Extension
+		if _, err := asn1.Unmarshal(rawExt.FullBytes, &ext); err != nil {
+			continue
+		}
+		cert.Extensions = append(cert.Extensions, ext)
+
+		switch {
+		case ext.Id.Equal(oidExtensionSubjectAltName):
+			names, err := lenientElements(ext.Value)
+			if err != nil {
+				continue
+			}
+			for _, name := range names {
+				switch name.Tag {
+				case 1:
+					cert.EmailAddresses = append(cert.EmailAddresses, string(name.Bytes))
+				case 2:
+					cert.DNSNames = append(cert.DNSNames, string(name.Bytes))
+				case 7:
+					if len(name.Bytes) == net.IPv4len || len(name.Bytes) == net.IPv6len {
+						cert.IPAddresses = append(cert.IPAddresses, net.IP(name.Bytes))
+					}
+				}
+			}
+
+		case ext.Id.Equal(oidExtensionAuthorityKeyId):
+			var aki lenientAuthorityKeyId
+			if _, err := asn1.Unmarshal(ext.Value, &aki); err == nil {
+				cert.AuthorityKeyId = aki.Id
+			}
+
+		case ext.Id.Equal(oidExtensionSubjectKeyId):
+			var ski []byte
+			if _, err := asn1.Unmarshal(ext.Value, &ski); err == nil {
+				cert.SubjectKeyId = ski
+			}
+
+		case ext.Id.Equal(oidExtensionBasicConstraints):
+			var constraints lenientBasicConstraints
+			if _, err := asn1.Unmarshal(ext.Value, &constraints); err == nil {
+				cert.BasicConstraintsValid = true
+				cert.IsCA = constraints.IsCA
+			}
+		}
+	}
+}
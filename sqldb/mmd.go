@@ -0,0 +1,165 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Checks that logs incorporated the certificates they issued SCTs for within
+// their maximum merge delay
+
+package sqldb
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Kinds of MMD violation
+const (
+	MMDViolationLate    = "late"    // Incorporated, but after the MMD had passed
+	MMDViolationMissing = "missing" // Not incorporated, though the MMD has passed
+)
+
+// The RFC 6962 default, for logs whose MMD we haven't been told
+const DefaultMMD = 24 * time.Hour
+
+type MMDViolation struct {
+	LogID        int           `db:"logID"`        // Log Identifier (FK to CertificateLog)
+	CertID       uint64        `db:"certID"`       // Internal Cert Identifier (FK to Certificate)
+	Kind         string        `db:"kind"`         // MMDViolationLate or MMDViolationMissing
+	SCTTime      time.Time     `db:"sctTime"`      // Timestamp of the SCT
+	ObservedTime time.Time     `db:"observedTime"` // Earliest the entry can have been incorporated, or when it was found missing
+	EntryID      sql.NullInt64 `db:"entryID"`      // Index of the late entry; null when missing
+	DelaySeconds int64         `db:"delaySeconds"` // ObservedTime less SCTTime
+	CheckedAt    time.Time     `db:"checkedAt"`    // Date when this check was run
+}
+
+type MMDReport struct {
+	LogID   int
+	MMD     time.Duration
+	Checked int // SCTs with a matching entry
+	Late    int
+	Missing int
+}
+
+func (edb *EntriesDatabase) GetLogs() ([]CertificateLog, error) {
+	var logObjs []CertificateLog
+	_, err := edb.DbMap.Select(&logObjs, "SELECT * FROM ctlog ORDER BY logID")
+	return logObjs, err
+}
+
+// MaxMergeDelay returns the maximum merge delay of the log
+func (certLogObj *CertificateLog) MaxMergeDelay() time.Duration {
+	if certLogObj.MMD <= 0 {
+		return DefaultMMD
+	}
+	return time.Duration(certLogObj.MMD) * time.Second
+}
+
+// CheckMMD compares every SCT the log issued against its entries, replacing
+// the log's previously recorded violations.
+//
+// An entry can't have been incorporated before any entry at a lower index,
+// each of which was incorporated no earlier than its leaf timestamp. So the
+// latest leaf timestamp below an entry is the earliest it can have been
+// incorporated; more than the MMD after its SCT is a violation.
+//
+// SCTs whose MMD expired before the last entry we've downloaded, but which
+// have no entry, are reported missing. That's only reliable with
+// correlateLogEntries set, and no gaps in the log's coverage.
+func (edb *EntriesDatabase) CheckMMD(certLogObj *CertificateLog) (*MMDReport, error) {
+	mmd := certLogObj.MaxMergeDelay()
+	report := &MMDReport{
+		LogID: certLogObj.LogID,
+		MMD:   mmd,
+	}
+	now := time.Now()
+
+	var violations []*MMDViolation
+
+	// The precertificate entry carries the SCT's own timestamp
+	rows, err := edb.DbMap.Db.Query(`SELECT e.entryID, e.certID, e.entryTime, s.timestamp
+		FROM ctlogentry AS e
+		LEFT JOIN sct AS s ON s.certID = e.certID AND s.logID = e.logID AND s.timestamp = e.entryTime
+		WHERE e.logID = ?
+		ORDER BY e.entryID`, certLogObj.LogID)
+	if err != nil {
+		return nil, err
+	}
+
+	var latestBefore time.Time
+	for rows.Next() {
+		var entryID int64
+		var certID uint64
+		var entryTime time.Time
+		var sctTime sql.NullString
+		if err = rows.Scan(&entryID, &certID, &entryTime, &sctTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if sctTime.Valid {
+			report.Checked++
+			if delay := latestBefore.Sub(entryTime); delay > mmd {
+				violations = append(violations, &MMDViolation{
+					LogID:        certLogObj.LogID,
+					CertID:       certID,
+					Kind:         MMDViolationLate,
+					SCTTime:      entryTime,
+					ObservedTime: latestBefore,
+					EntryID:      sql.NullInt64{Int64: entryID, Valid: true},
+					DelaySeconds: int64(delay.Seconds()),
+					CheckedAt:    now,
+				})
+				report.Late++
+			}
+		}
+
+		if entryTime.After(latestBefore) {
+			latestBefore = entryTime
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []EmbeddedSCT
+	_, err = edb.DbMap.Select(&missing, `SELECT * FROM sct AS s
+		WHERE s.logID = ? AND s.timestamp < ? AND NOT EXISTS
+			(SELECT 1 FROM ctlogentry AS e WHERE e.logID = s.logID AND e.certID = s.certID AND e.entryTime = s.timestamp)`,
+		certLogObj.LogID, certLogObj.LastEntryTime.Add(-mmd))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sct := range missing {
+		violations = append(violations, &MMDViolation{
+			LogID:        certLogObj.LogID,
+			CertID:       sct.CertID,
+			Kind:         MMDViolationMissing,
+			SCTTime:      sct.Timestamp,
+			ObservedTime: now,
+			DelaySeconds: int64(now.Sub(sct.Timestamp).Seconds()),
+			CheckedAt:    now,
+		})
+		report.Missing++
+	}
+
+	txn, err := edb.DbMap.Begin()
+	if err != nil {
+		return nil, err
+	}
+	_, err = txn.Exec("DELETE FROM mmd_violation WHERE logID = ?", certLogObj.LogID)
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+	for _, violation := range violations {
+		err = txn.Insert(violation)
+		if errorIsNotDuplicate(err) {
+			txn.Rollback()
+			return nil, err
+		}
+	}
+	return report, txn.Commit()
+}
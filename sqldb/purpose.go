@@ -0,0 +1,90 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Classifies certificates by what they're for, as not everything logged is a
+// TLS server certificate
+
+package sqldb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// Certificate purposes
+const (
+	PurposeCA          = "ca"           // A CA certificate
+	PurposeTLSServer   = "tls-server"   // Usable for TLS servers
+	PurposeTLSClient   = "tls-client"   // Only usable for TLS clients
+	PurposeSMIME       = "smime"        // Email protection
+	PurposeCodeSigning = "code-signing" // Code signing
+	PurposeOther       = "other"        // None of the above, such as OCSP or timestamp signing
+)
+
+var certPurposes = []string{PurposeCA, PurposeTLSServer, PurposeTLSClient, PurposeSMIME, PurposeCodeSigning, PurposeOther}
+
+// ParsePurposes reads a comma delimited list of purposes into a set.
+func ParsePurposes(list string) (map[string]bool, error) {
+	purposes := make(map[string]bool)
+	for _, part := range strings.Split(list, ",") {
+		purpose := strings.TrimSpace(part)
+		if len(purpose) == 0 {
+			continue
+		}
+		known := false
+		for _, p := range certPurposes {
+			known = known || p == purpose
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown purpose %q, expected one of %s", purpose, strings.Join(certPurposes, ", "))
+		}
+		purposes[purpose] = true
+	}
+	return purposes, nil
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// certPurpose classifies the certificate by its extended key usages, taking
+// the first of TLS server, S/MIME, code signing and TLS client it allows.
+// Without extended key usages, it's a TLS server certificate if its key usage
+// permits, unless it names only email addresses.
+func certPurpose(cert *x509.Certificate) string {
+	if cert.BasicConstraintsValid && cert.IsCA {
+		return PurposeCA
+	}
+
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		tlsKeyUsage := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement
+		if cert.KeyUsage != 0 && cert.KeyUsage&tlsKeyUsage == 0 {
+			return PurposeOther
+		}
+		if len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 &&
+			(len(cert.EmailAddresses) > 0 || strings.Contains(cert.Subject.CommonName, "@")) {
+			return PurposeSMIME
+		}
+		return PurposeTLSServer
+	}
+
+	switch {
+	case hasExtKeyUsage(cert, x509.ExtKeyUsageServerAuth), hasExtKeyUsage(cert, x509.ExtKeyUsageAny):
+		return PurposeTLSServer
+	case hasExtKeyUsage(cert, x509.ExtKeyUsageEmailProtection):
+		return PurposeSMIME
+	case hasExtKeyUsage(cert, x509.ExtKeyUsageCodeSigning):
+		return PurposeCodeSigning
+	case hasExtKeyUsage(cert, x509.ExtKeyUsageClientAuth):
+		return PurposeTLSClient
+	}
+	return PurposeOther
+}
@@ -0,0 +1,161 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Tracks which roots each log accepts, and how that changes over time
+
+package sqldb
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/certificate-transparency/go"
+	"golang.org/x/net/context"
+)
+
+// Kinds of accepted root change
+const (
+	RootAdded   = "added"
+	RootRemoved = "removed"
+)
+
+type LogRoot struct {
+	LogID     int       `db:"logID"`     // Log Identifier (FK to CertificateLog)
+	CertID    uint64    `db:"certID"`    // Internal Cert Identifier of the root (FK to Certificate)
+	FirstSeen time.Time `db:"firstSeen"` // Date when the log was first seen accepting this root
+}
+
+type LogRootChange struct {
+	LogID  int       `db:"logID"`  // Log Identifier (FK to CertificateLog)
+	CertID uint64    `db:"certID"` // Internal Cert Identifier of the root (FK to Certificate)
+	Kind   string    `db:"kind"`   // RootAdded or RootRemoved
+	Time   time.Time `db:"time"`   // Date when the change was noticed
+}
+
+// getOrInsertRoot stores the root as a certificate, if it isn't one already.
+// Roots are their own issuers, and often name no authority key ID, so they
+// are given their subject key ID as one; failing that, the SHA-1 of their
+// key. Otherwise every such root would share a single issuer. Their CNs
+// aren't hostnames, so they're stored without names.
+func (edb *EntriesDatabase) getOrInsertRoot(ctx context.Context, der []byte) (uint64, error) {
+	// Logs do serve roots that x509 won't parse
+	cert, err := ParseCertificateLeniently(der, false)
+	if err != nil {
+		return 0, ParseError{err}
+	}
+
+	fingerprint := certFingerprint(cert)
+	var certId uint64
+	err = edb.DbMap.SelectOne(&certId, "SELECT certID FROM certificate_fingerprint WHERE fingerprint = ?", fingerprint)
+	switch {
+	case err == nil && certId != 0:
+		return certId, nil
+	case err != nil && err != sql.ErrNoRows:
+		return 0, err
+	}
+
+	root := *cert
+	if len(root.AuthorityKeyId) == 0 {
+		root.AuthorityKeyId = root.SubjectKeyId
+	}
+	if len(root.AuthorityKeyId) == 0 {
+		keyHash := sha1.Sum(root.RawSubjectPublicKeyInfo)
+		root.AuthorityKeyId = keyHash[:]
+	}
+
+	txn, certId, err := edb.insertCertificate(ctx, &root, fingerprint, nil, false)
+	if err != nil {
+		if txn != nil {
+			txn.Rollback()
+		}
+		return 0, err
+	}
+	if err = txn.Commit(); err != nil {
+		return 0, err
+	}
+	edb.rememberCertificate(fingerprint)
+	return certId, nil
+}
+
+// UpdateAcceptedRoots stores the roots the log now accepts as certificates,
+// and replaces those linked to the log with them, noting each addition and
+// removal. Roots that can't be parsed at all are skipped. Returns the number
+// added and removed.
+func (edb *EntriesDatabase) UpdateAcceptedRoots(ctx context.Context, logID int, roots []ct.ASN1Cert) (int, int, error) {
+	now := time.Now()
+
+	var rootIDs []uint64
+	for _, der := range roots {
+		certId, err := edb.getOrInsertRoot(ctx, der)
+		if err != nil {
+			if _, ok := err.(ParseError); ok {
+				log.Printf("[log %d] Skipping accepted root: %s", logID, err)
+				continue
+			}
+			return 0, 0, fmt.Errorf("DB error on accepted root: %s", err)
+		}
+		rootIDs = append(rootIDs, certId)
+	}
+
+	txn, err := edb.DbMap.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var current []LogRoot
+	_, err = txn.Select(&current, "SELECT * FROM ctlog_root WHERE logID = ?", logID)
+	if err != nil {
+		txn.Rollback()
+		return 0, 0, err
+	}
+
+	previous := make(map[uint64]bool)
+	for _, logRoot := range current {
+		previous[logRoot.CertID] = true
+	}
+
+	var added int
+	seen := make(map[uint64]bool)
+	for _, rootID := range rootIDs {
+		if seen[rootID] {
+			continue
+		}
+		seen[rootID] = true
+		if previous[rootID] {
+			continue
+		}
+
+		err = txn.Insert(&LogRoot{LogID: logID, CertID: rootID, FirstSeen: now},
+			&LogRootChange{LogID: logID, CertID: rootID, Kind: RootAdded, Time: now})
+		if err != nil {
+			txn.Rollback()
+			return 0, 0, err
+		}
+		added++
+	}
+
+	var removed int
+	for rootID := range previous {
+		if seen[rootID] {
+			continue
+		}
+
+		_, err = txn.Exec("DELETE FROM ctlog_root WHERE logID = ? AND certID = ?", logID, rootID)
+		if err != nil {
+			txn.Rollback()
+			return 0, 0, err
+		}
+		err = txn.Insert(&LogRootChange{LogID: logID, CertID: rootID, Kind: RootRemoved, Time: now})
+		if err != nil {
+			txn.Rollback()
+			return 0, 0, err
+		}
+		removed++
+	}
+
+	return added, removed, txn.Commit()
+}
@@ -0,0 +1,223 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Extracts the SCTs embedded in certificates, and verifies them against the
+// keys of the logs we know
+
+package sqldb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	"github.com/google/certificate-transparency/go"
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/jcjones/ct-sql/utils"
+)
+
+// RFC 6962 Section 3.3
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+type EmbeddedSCT struct {
+	CertID    uint64       `db:"certID"`    // Internal Cert Identifier (FK to Certificate)
+	KeyID     string       `db:"keyID"`     // Base64 SHA-256 of the issuing log's key
+	LogID     int          `db:"logID"`     // Log Identifier (FK to CertificateLog), zero if the log is unknown
+	Timestamp time.Time    `db:"timestamp"` // Date the log issued this SCT
+	Signature []byte       `db:"signature"` // The log's TLS DigitallySigned signature
+	Verified  sql.NullBool `db:"verified"`  // Null when we lack the log's key or the issuer to check it
+	Version   int          `db:"version"`   // SCT version
+}
+
+// logKey is a known log's signature verifier, cached by key ID
+type logKey struct {
+	logID    int
+	verifier *ct.SignatureVerifier
+}
+
+// SetLogKey stores the PEM-encoded public key of the log, so SCTs it issued
+// can be attributed to it and verified.
+func (edb *EntriesDatabase) SetLogKey(certLogObj *CertificateLog, publicKeyPEM []byte) error {
+	_, keyID, _, err := ct.PublicKeyFromPEM(publicKeyPEM)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(publicKeyPEM)
+
+	certLogObj.PublicKey = block.Bytes
+	certLogObj.KeyID = keyID.Base64String()
+
+	edb.LogKeysLock.Lock()
+	delete(edb.KnownLogKeys, certLogObj.KeyID)
+	edb.LogKeysLock.Unlock()
+
+	return edb.SaveLogState(certLogObj)
+}
+
+// lookupLogKey finds the log with the given key ID. A nil result with no
+// error means no log with that key is known; that's cached too.
+func (edb *EntriesDatabase) lookupLogKey(keyID string) (*logKey, error) {
+	edb.LogKeysLock.RLock()
+	key, ok := edb.KnownLogKeys[keyID]
+	edb.LogKeysLock.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	var logObjs []CertificateLog
+	_, err := edb.DbMap.Select(&logObjs, "SELECT * FROM ctlog WHERE keyID = ? LIMIT 1", keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(logObjs) > 0 {
+		pubKey, err := x509.ParsePKIXPublicKey(logObjs[0].PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("Bad public key for log %d: %s", logObjs[0].LogID, err)
+		}
+		verifier, err := ct.NewSignatureVerifier(pubKey)
+		if err != nil {
+			return nil, fmt.Errorf("Bad public key for log %d: %s", logObjs[0].LogID, err)
+		}
+		key = &logKey{logID: logObjs[0].LogID, verifier: verifier}
+	}
+
+	edb.LogKeysLock.Lock()
+	if edb.KnownLogKeys == nil {
+		edb.KnownLogKeys = make(map[string]*logKey)
+	}
+	edb.KnownLogKeys[keyID] = key
+	edb.LogKeysLock.Unlock()
+	return key, nil
+}
+
+// parseEmbeddedSCTs decodes the SignedCertificateTimestampList extension, if
+// the certificate has one.
+func parseEmbeddedSCTs(cert *x509.Certificate) ([]ct.SignedCertificateTimestamp, error) {
+	var extValue []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidSCTList) {
+			extValue = ext.Value
+			break
+		}
+	}
+	if extValue == nil {
+		return nil, nil
+	}
+
+	// The TLS-encoded list is itself wrapped in an OCTET STRING
+	var listBytes []byte
+	if _, err := asn1.Unmarshal(extValue, &listBytes); err != nil {
+		return nil, err
+	}
+	if len(listBytes) < 2 || int(binary.BigEndian.Uint16(listBytes)) != len(listBytes)-2 {
+		return nil, fmt.Errorf("SCT list has a bad length")
+	}
+
+	var scts []ct.SignedCertificateTimestamp
+	reader := bytes.NewReader(listBytes[2:])
+	for reader.Len() > 0 {
+		var sctLen uint16
+		if err := binary.Read(reader, binary.BigEndian, &sctLen); err != nil {
+			return nil, err
+		}
+		sctBytes := make([]byte, sctLen)
+		if _, err := io.ReadFull(reader, sctBytes); err != nil {
+			return nil, err
+		}
+		sct, err := ct.DeserializeSCT(bytes.NewReader(sctBytes))
+		if err != nil {
+			return nil, err
+		}
+		scts = append(scts, *sct)
+	}
+	return scts, nil
+}
+
+// precertEntry rebuilds the precertificate log entry the SCT was signed over:
+// the certificate's TBS with the SCT list removed, and the hash of its
+// issuer's key.
+func precertEntry(cert *x509.Certificate, issuer *x509.Certificate, sct ct.SignedCertificateTimestamp) (*ct.LogEntry, error) {
+	tbs, err := utils.RemoveExtension(cert.RawTBSCertificate, oidSCTList)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ct.LogEntry{
+		Leaf: ct.MerkleTreeLeaf{
+			Version:  ct.V1,
+			LeafType: ct.TimestampedEntryLeafType,
+			TimestampedEntry: ct.TimestampedEntry{
+				Timestamp: sct.Timestamp,
+				EntryType: ct.PrecertLogEntryType,
+				PrecertEntry: ct.PreCert{
+					IssuerKeyHash:  sha256.Sum256(issuer.RawSubjectPublicKeyInfo),
+					TBSCertificate: tbs,
+				},
+				Extensions: sct.Extensions,
+			},
+		},
+	}, nil
+}
+
+// insertEmbeddedSCTs stores each SCT in the certificate, verifying those from
+// logs whose keys we have. The issuer may be nil, in which case nothing can
+// be verified. Unparseable SCT lists are not fatal to the certificate.
+func (edb *EntriesDatabase) insertEmbeddedSCTs(txn *gorp.Transaction, certID uint64, cert *x509.Certificate, issuer *x509.Certificate) error {
+	scts, err := parseEmbeddedSCTs(cert)
+	if err != nil {
+		if edb.Verbose {
+			log.Printf("Unable to parse embedded SCTs of certId %d: %s", certID, err)
+		}
+		return nil
+	}
+
+	for _, sct := range scts {
+		signature, err := ct.MarshalDigitallySigned(sct.Signature)
+		if err != nil {
+			return err
+		}
+
+		sctObj := &EmbeddedSCT{
+			CertID:    certID,
+			KeyID:     sct.LogID.Base64String(),
+			Timestamp: utils.Uint64ToTimestamp(sct.Timestamp),
+			Signature: signature,
+			Version:   int(sct.SCTVersion),
+		}
+
+		key, err := edb.lookupLogKey(sctObj.KeyID)
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			sctObj.LogID = key.logID
+			if issuer != nil {
+				sctObj.Verified.Valid = true
+				entry, err := precertEntry(cert, issuer, sct)
+				if err == nil {
+					err = key.verifier.VerifySCTSignature(sct, *entry)
+				}
+				sctObj.Verified.Bool = (err == nil)
+				if err != nil && edb.Verbose {
+					log.Printf("SCT from log %d on certId %d did not verify: %s", key.logID, certID, err)
+				}
+			}
+		}
+
+		err = txn.Insert(sctObj)
+		if errorIsNotDuplicate(err) {
+			return fmt.Errorf("DB error on SCT: %#v: %s", sctObj, err)
+		}
+	}
+	return nil
+}
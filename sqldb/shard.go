@@ -0,0 +1,163 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Logs sharded by certificate expiry only accept certificates whose notAfter
+// falls within their shard
+
+package sqldb
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+type ShardViolation struct {
+	LogID      int       `db:"logID"`      // Log Identifier (FK to CertificateLog)
+	EntryID    uint64    `db:"entryID"`    // Index within the log
+	NotAfter   time.Time `db:"notAfter"`   // The certificate's notAfter
+	ShardStart time.Time `db:"shardStart"` // The log's shard at the time
+	ShardEnd   time.Time `db:"shardEnd"`   // The log's shard at the time
+	SeenAt     time.Time `db:"seenAt"`     // Date when the entry was found
+}
+
+type logShard struct {
+	start time.Time
+	end   time.Time
+}
+
+// ShardError marks a certificate whose notAfter lies outside the shard of the
+// log it was found in.
+type ShardError struct {
+	NotAfter time.Time
+	Start    time.Time
+	End      time.Time
+}
+
+func (e ShardError) Error() string {
+	return fmt.Sprintf("certificate expiring %s is outside log shard [%s, %s)",
+		e.NotAfter.Format(time.RFC3339), e.Start.Format(time.RFC3339), e.End.Format(time.RFC3339))
+}
+
+// ParseShard reads a shard given as "start:end" dates (YYYY-MM-DD), the end
+// exclusive.
+func ParseShard(shard string) (time.Time, time.Time, error) {
+	parts := strings.Split(strings.TrimSpace(shard), ":")
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("Bad shard %q, expected start:end", shard)
+	}
+	start, err := time.Parse("2006-01-02", parts[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Bad shard start %q: %s", parts[0], err)
+	}
+	end, err := time.Parse("2006-01-02", parts[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Bad shard end %q: %s", parts[1], err)
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("Bad shard %q, end is not after start", shard)
+	}
+	return start, end, nil
+}
+
+// IsSharded is true if the log only accepts certificates expiring within
+// [ShardStart, ShardEnd).
+func (certLogObj *CertificateLog) IsSharded() bool {
+	return !certLogObj.ShardEnd.IsZero()
+}
+
+func (edb *EntriesDatabase) SetLogShard(certLogObj *CertificateLog, start, end time.Time) error {
+	certLogObj.ShardStart = start.UTC()
+	certLogObj.ShardEnd = end.UTC()
+
+	edb.LogShardsLock.Lock()
+	delete(edb.KnownLogShards, certLogObj.LogID)
+	edb.LogShardsLock.Unlock()
+
+	return edb.SaveLogState(certLogObj)
+}
+
+// ShardIsFilteredOut is true if every certificate the log's shard could hold
+// would be filtered out anyway, so there's no point downloading it.
+func (edb *EntriesDatabase) ShardIsFilteredOut(certLogObj *CertificateLog) bool {
+	if !certLogObj.IsSharded() {
+		return false
+	}
+
+	// Certificates expiring before the earliest date can't have been issued
+	// after it
+	if !edb.EarliestDateFilter.IsZero() && !certLogObj.ShardEnd.After(edb.EarliestDateFilter) {
+		return true
+	}
+
+	return !certLogObj.ShardEnd.After(time.Now()) && !edb.LogExpiredEntries
+}
+
+// lookupLogShard finds the shard of the given log; the zero logShard if it
+// isn't sharded.
+func (edb *EntriesDatabase) lookupLogShard(logID int) (logShard, error) {
+	edb.LogShardsLock.RLock()
+	shard, ok := edb.KnownLogShards[logID]
+	edb.LogShardsLock.RUnlock()
+	if ok {
+		return shard, nil
+	}
+
+	certLogObj, err := edb.GetLogByID(logID)
+	if err != nil {
+		return shard, err
+	}
+	shard = logShard{start: certLogObj.ShardStart, end: certLogObj.ShardEnd}
+
+	edb.LogShardsLock.Lock()
+	if edb.KnownLogShards == nil {
+		edb.KnownLogShards = make(map[int]logShard)
+	}
+	edb.KnownLogShards[logID] = shard
+	edb.LogShardsLock.Unlock()
+	return shard, nil
+}
+
+// checkShard returns a ShardError if the certificate doesn't belong in the
+// log's shard.
+func (edb *EntriesDatabase) checkShard(cert *x509.Certificate, logID int) error {
+	shard, err := edb.lookupLogShard(logID)
+	if err != nil {
+		return err
+	}
+	if shard.end.IsZero() {
+		return nil
+	}
+	if cert.NotAfter.Before(shard.start) || !cert.NotAfter.Before(shard.end) {
+		return ShardError{NotAfter: cert.NotAfter, Start: shard.start, End: shard.end}
+	}
+	return nil
+}
+
+// recordShardViolation checks the certificate against the log's shard. One
+// that doesn't belong there is recorded as a ShardViolation, a finding about
+// the log rather than a failure to insert, so it is never retried; its
+// ShardError is returned.
+func (edb *EntriesDatabase) recordShardViolation(cert *x509.Certificate, logID int, entryID uint64) error {
+	err := edb.checkShard(cert, logID)
+	shardErr, ok := err.(ShardError)
+	if !ok {
+		return err
+	}
+
+	if edb.Verbose {
+		log.Printf("Entry %d of log %d: %s", entryID, logID, shardErr)
+	}
+	_, err = edb.DbMap.Exec(`INSERT INTO shard_violation
+		(logID, entryID, notAfter, shardStart, shardEnd, seenAt) VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE seenAt = VALUES(seenAt)`,
+		logID, entryID, shardErr.NotAfter.UTC(), shardErr.Start, shardErr.End, time.Now())
+	if err != nil {
+		return err
+	}
+	return shardErr
+}
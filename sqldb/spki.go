@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Indexes certificates by their public key, to find keys shared across
+// certificates, registered domains and issuers
+
+package sqldb
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+type CertSPKI struct {
+	CertID   uint64 `db:"certID"`   // Internal Cert Identifier (FK to Certificate)
+	SPKIHash []byte `db:"spkiHash"` // SHA-256 of the DER SubjectPublicKeyInfo
+}
+
+// KeyReuse describes a public key found in several certificates
+type KeyReuse struct {
+	SPKIHash          []byte    `db:"spkiHash" json:"spkiHash"`                   // SHA-256 of the DER SubjectPublicKeyInfo
+	Certificates      int       `db:"certificates" json:"certificates"`           // Certificates with the key
+	RegisteredDomains int       `db:"registeredDomains" json:"registeredDomains"` // Registered domains those certificates name
+	Issuers           int       `db:"issuers" json:"issuers"`                     // Issuers of those certificates
+	FirstSeen         time.Time `db:"firstSeen" json:"firstSeen"`                 // Earliest notBefore of those certificates
+	LastSeen          time.Time `db:"lastSeen" json:"lastSeen"`                   // Latest notBefore of those certificates
+}
+
+func spkiHash(cert *x509.Certificate) []byte {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return digest[:]
+}
+
+func (edb *EntriesDatabase) insertSPKIHash(txn *gorp.Transaction, certID uint64, cert *x509.Certificate) error {
+	err := txn.Insert(&CertSPKI{CertID: certID, SPKIHash: spkiHash(cert)})
+	if errorIsNotDuplicate(err) {
+		return err
+	}
+	return nil
+}
+
+// GetCertsWithoutSPKIHash returns the certificates whose keys aren't
+// indexed, as those inserted before they were, after the last one the
+// backfill tried. A limit of zero returns them all.
+func (edb *EntriesDatabase) GetCertsWithoutSPKIHash(limit uint64) ([]uint64, error) {
+	lastCertID, err := edb.getBackfillProgress(BackfillSPKI)
+	if err != nil {
+		return nil, err
+	}
+
+	var certIDs []uint64
+	query := `SELECT c.certID FROM certificate AS c
+		LEFT JOIN cert_spki AS k ON k.certID = c.certID
+		WHERE k.certID IS NULL AND c.certID > ? ORDER BY c.certID`
+	if limit > 0 {
+		_, err = edb.DbMap.Select(&certIDs, query+" LIMIT ?", lastCertID, limit)
+	} else {
+		_, err = edb.DbMap.Select(&certIDs, query, lastCertID)
+	}
+	return certIDs, err
+}
+
+// BackfillSPKIHash indexes the key of the certificate, read from the stored
+// certificates. Returns false if it couldn't be read, which a database error
+// doesn't count as.
+func (edb *EntriesDatabase) BackfillSPKIHash(certID uint64) (bool, error) {
+	if edb.FullCerts == nil {
+		return false, fmt.Errorf("No certificate folder configured")
+	}
+	cert, err := edb.storedCertificate(certID)
+	if err != nil {
+		if edb.Verbose {
+			log.Printf("Unable to read the key of certID %d: %s", certID, err)
+		}
+		return false, nil
+	}
+	err = edb.DbMap.Insert(&CertSPKI{CertID: certID, SPKIHash: spkiHash(cert)})
+	if errorIsNotDuplicate(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetKeyReuse returns the keys used by more than minCerts certificates, or
+// whose certificates name more than minDomains registered domains, the most
+// widely spread first.
+func (edb *EntriesDatabase) GetKeyReuse(minCerts, minDomains int) ([]KeyReuse, error) {
+	var reuse []KeyReuse
+	_, err := edb.DbMap.Select(&reuse, `SELECT k.spkiHash,
+			COUNT(DISTINCT k.certID) AS certificates,
+			COUNT(DISTINCT r.regdomID) AS registeredDomains,
+			COUNT(DISTINCT c.issuerID) AS issuers,
+			MIN(c.notBefore) AS firstSeen, MAX(c.notBefore) AS lastSeen
+		FROM cert_spki AS k
+		JOIN certificate AS c ON c.certID = k.certID
+		LEFT JOIN cert_registereddomain AS r ON r.certID = k.certID
+		GROUP BY k.spkiHash
+		HAVING certificates > ? OR registeredDomains > ?
+		ORDER BY registeredDomains DESC, certificates DESC`, minCerts, minDomains)
+	return reuse, err
+}
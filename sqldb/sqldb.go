@@ -7,6 +7,7 @@
 package sqldb
 
 import (
+	"database/sql"
 	"encoding/base64"
 	"fmt"
 	"log"
@@ -22,6 +23,7 @@ import (
 	"github.com/jcjones/ct-sql/censysdata"
 	"github.com/jcjones/ct-sql/utils"
 	"github.com/jpillora/backoff"
+	"golang.org/x/net/context"
 	"golang.org/x/net/publicsuffix"
 )
 
@@ -32,6 +34,7 @@ type Certificate struct {
 	Subject   string    `db:"subject"`                           // The Subject field of this cert
 	NotBefore time.Time `db:"notBefore"`                         // Date before which this cert should be considered invalid
 	NotAfter  time.Time `db:"notAfter"`                          // Date after which this cert should be considered invalid
+	Purpose   string    `db:"purpose"`                           // One of the Purpose constants
 }
 
 type UnexpiredCertificate struct {
@@ -74,13 +77,28 @@ type CertificateLog struct {
 	URL           string    `db:"url"`                              // URL to the log
 	MaxEntry      uint64    `db:"maxEntry"`                         // The most recent entryID logged
 	LastEntryTime time.Time `db:"lastEntryTime"`                    // Date when we completed the last update
+	PublicKey     []byte    `db:"publicKey"`                        // DER-encoded public key of the log, if known
+	KeyID         string    `db:"keyID"`                            // Base64 SHA-256 of PublicKey, as found in SCTs
+	MMD           int       `db:"mmd"`                              // Maximum merge delay in seconds
+	Operator      string    `db:"operator"`                         // Organization operating the log
+	ShardStart    time.Time `db:"shardStart"`                       // Earliest notAfter the log accepts, if sharded
+	ShardEnd      time.Time `db:"shardEnd"`                         // notAfter before which certs must expire, if sharded
+	Tiled         bool      `db:"tiled"`                            // Whether the log is read through the Static CT API
 }
 
 type CertificateLogEntry struct {
-	CertID    uint64    `db:"certID"`    // Internal Cert Identifier (FK to Certificate)
-	LogID     int       `db:"logID"`     // Log Identifier (FK to CertificateLog)
-	EntryID   uint64    `db:"entryId"`   // Entry Identifier within the log
-	EntryTime time.Time `db:"entryTime"` // Date when this certificate was added to the log
+	CertID    uint64        `db:"certID"`    // Internal Cert Identifier (FK to Certificate)
+	LogID     int           `db:"logID"`     // Log Identifier (FK to CertificateLog)
+	EntryID   uint64        `db:"entryId"`   // Entry Identifier within the log
+	EntryTime time.Time     `db:"entryTime"` // Date when this certificate was added to the log
+	EntryType sql.NullInt64 `db:"entryType"` // RFC 6962 LogEntryType, null for entries recorded before it was kept
+}
+
+type LogThrottleEvent struct {
+	LogID      int       `db:"logID"`      // Log Identifier (FK to CertificateLog)
+	Time       time.Time `db:"time"`       // Date when the log asked us to slow down
+	StatusCode int       `db:"statusCode"` // HTTP status returned by the log
+	RetryAfter int       `db:"retryAfter"` // Seconds the log asked us to wait, if given
 }
 
 type CensysEntry struct {
@@ -133,6 +151,15 @@ type EntriesDatabase struct {
 	EarliestDateFilter  time.Time
 	CorrelateLogEntries bool
 	LogExpiredEntries   bool
+	KnownLogKeys        map[string]*logKey
+	LogKeysLock         sync.RWMutex
+	KnownLogShards      map[int]logShard
+	LogShardsLock       sync.RWMutex
+	KnownCerts          *utils.BloomFilter
+	ExcludedPurposes    map[string]bool
+	UnnamedPurposes     map[string]bool
+	KnownConstraints    map[int]struct{}
+	ConstraintsLock     sync.RWMutex
 }
 
 // Taken from Boulder
@@ -195,12 +222,33 @@ func (edb *EntriesDatabase) InitTables() error {
 	edb.DbMap.AddTableWithName(NetscanQueue{}, "netscanqueue")
 	edb.DbMap.AddTableWithName(FirefoxPageloadIsTLS{}, "firefoxpageloadstls")
 	edb.DbMap.AddTableWithName(UnexpiredCertificate{}, "unexpired_certificate")
+	edb.DbMap.AddTableWithName(LogThrottleEvent{}, "logthrottle")
+	edb.DbMap.AddTableWithName(LogCoverage{}, "ctlogcoverage")
+	edb.DbMap.AddTableWithName(EmbeddedSCT{}, "sct")
+	edb.DbMap.AddTableWithName(MMDViolation{}, "mmd_violation")
+	edb.DbMap.AddTableWithName(LogRoot{}, "ctlog_root")
+	edb.DbMap.AddTableWithName(LogRootChange{}, "ctlog_root_change")
+	edb.DbMap.AddTableWithName(Submission{}, "submission")
+	edb.DbMap.AddTableWithName(LogHealth{}, "log_health")
+	edb.DbMap.AddTableWithName(LogLease{}, "ctlog_lease")
+	edb.DbMap.AddTableWithName(CertFingerprint{}, "certificate_fingerprint")
+	edb.DbMap.AddTableWithName(UnparseableEntry{}, "unparseable_entry")
+	edb.DbMap.AddTableWithName(NameConstraint{}, "name_constraint")
+	edb.DbMap.AddTableWithName(NameConstraintViolation{}, "name_constraint_violation")
+	edb.DbMap.AddTableWithName(CertSPKI{}, "cert_spki")
+	edb.DbMap.AddTableWithName(KeyFinding{}, "key_finding")
+	edb.DbMap.AddTableWithName(ShardViolation{}, "shard_violation")
+	edb.DbMap.AddTableWithName(BackfillProgress{}, "backfill_progress")
 
 	edb.DbMap.AddTableWithName(RegisteredDomain{}, "registereddomain").SetKeys(true, "regdomID")
 	edb.DbMap.AddTableWithName(CertificateLog{}, "ctlog").SetKeys(true, "LogID")
 	edb.DbMap.AddTableWithName(Certificate{}, "certificate").SetKeys(true, "CertID")
 	edb.DbMap.AddTableWithName(FQDN{}, "fqdn").SetKeys(true, "NameID")
 	edb.DbMap.AddTableWithName(Issuer{}, "issuer").SetKeys(true, "IssuerID")
+	edb.DbMap.AddTableWithName(FailedEntry{}, "failed_entry").SetKeys(true, "FailureID")
+	edb.DbMap.AddTableWithName(CertCompliance{}, "ct_compliance").SetKeys(false, "CertID")
+	edb.DbMap.AddTableWithName(Organization{}, "organization").SetKeys(true, "OrgID")
+	edb.DbMap.AddTableWithName(CertSubject{}, "cert_subject").SetKeys(false, "CertID")
 
 	// All is well, no matter what.
 	return nil
@@ -219,19 +267,46 @@ func (edb *EntriesDatabase) GetLogState(url string) (*CertificateLog, error) {
 	return &certLogObj, err
 }
 
+func (edb *EntriesDatabase) GetLogByID(logID int) (*CertificateLog, error) {
+	obj, err := edb.DbMap.Get(CertificateLog{}, logID)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("No log with ID %d", logID)
+	}
+	return obj.(*CertificateLog), nil
+}
+
 func (edb *EntriesDatabase) SaveLogState(certLogObj *CertificateLog) error {
 	_, err := edb.DbMap.Update(certLogObj)
 	return err
 }
 
-func (edb *EntriesDatabase) insertCertificate(cert *x509.Certificate) (*gorp.Transaction, uint64, error) {
+func (edb *EntriesDatabase) InsertThrottleEvent(logID int, statusCode int, retryAfter time.Duration) error {
+	obj := &LogThrottleEvent{
+		LogID:      logID,
+		Time:       time.Now(),
+		StatusCode: statusCode,
+		RetryAfter: int(retryAfter.Seconds()),
+	}
+	return edb.DbMap.Insert(obj)
+}
+
+// insertCertificate adds the certificate and its metadata. The chain is
+// optional; it's stored with the full certificate, and used to verify
+// embedded SCTs. Certificates that aren't named aren't indexed by their CN
+// and SAN, so they aren't queued for resolving either. Contention over the
+// issuer is retried until ctx is done.
+func (edb *EntriesDatabase) insertCertificate(ctx context.Context, cert *x509.Certificate, fingerprint []byte, chain *StoredChain, named bool) (*gorp.Transaction, uint64, error) {
 	//
 	// Find the Certificate's issuing CA, using a loop since this is contentious.
 	// Also, this is lame. TODO: Be smarter with insertion mutexes
 	//
 
 	var issuerID int
-	authorityKeyId := base64.StdEncoding.EncodeToString(cert.AuthorityKeyId)
+	rawAuthorityKeyId, issuerCommonName := issuerIdentity(cert, chain)
+	authorityKeyId := base64.StdEncoding.EncodeToString(rawAuthorityKeyId)
 	edb.IssuersLock.RLock()
 	issuerID, issuerIsInMap := edb.KnownIssuers[authorityKeyId]
 	edb.IssuersLock.RUnlock()
@@ -252,7 +327,7 @@ func (edb *EntriesDatabase) insertCertificate(cert *x509.Certificate) (*gorp.Tra
 				//
 				issuerObj := &Issuer{
 					AuthorityKeyId: authorityKeyId,
-					CommonName:     cert.Issuer.CommonName,
+					CommonName:     issuerCommonName,
 				}
 				err = edb.DbMap.Insert(issuerObj)
 				if err == nil {
@@ -261,7 +336,11 @@ func (edb *EntriesDatabase) insertCertificate(cert *x509.Certificate) (*gorp.Tra
 					break
 				}
 				log.Printf("Collision on issuer %v, retrying", issuerObj)
-				time.Sleep(backoff.Duration())
+				select {
+				case <-ctx.Done():
+					return nil, 0, ctx.Err()
+				case <-time.After(backoff.Duration()):
+				}
 			} else {
 				break
 			}
@@ -278,6 +357,11 @@ func (edb *EntriesDatabase) insertCertificate(cert *x509.Certificate) (*gorp.Tra
 		edb.IssuersLock.Unlock()
 	}
 
+	err := edb.recordNameConstraints(issuerID, chain)
+	if err != nil {
+		return nil, 0, fmt.Errorf("DB error on name constraints of issuer %d: %s", issuerID, err)
+	}
+
 	//
 	// Find/insert the Certificate from/into the DB
 	//
@@ -291,6 +375,7 @@ func (edb *EntriesDatabase) insertCertificate(cert *x509.Certificate) (*gorp.Tra
 
 	// Parse the serial number
 	serialNum := fmt.Sprintf("%036x", cert.SerialNumber)
+	purpose := certPurpose(cert)
 
 	certObj := &Certificate{
 		Serial:    serialNum,
@@ -298,6 +383,7 @@ func (edb *EntriesDatabase) insertCertificate(cert *x509.Certificate) (*gorp.Tra
 		Subject:   cert.Subject.CommonName,
 		NotBefore: cert.NotBefore.UTC(),
 		NotAfter:  cert.NotAfter.UTC(),
+		Purpose:   purpose,
 	}
 
 	//
@@ -344,6 +430,16 @@ func (edb *EntriesDatabase) insertCertificate(cert *x509.Certificate) (*gorp.Tra
 		return txn, 0, fmt.Errorf("Failed to locate a certId for certificate serial=%s", serialNum)
 	}
 
+	err = edb.insertFingerprint(txn, certId, fingerprint)
+	if err != nil {
+		return txn, certId, fmt.Errorf("DB error on certId %d fingerprint: %s", certId, err)
+	}
+
+	err = edb.insertSPKIHash(txn, certId, cert)
+	if err != nil {
+		return txn, certId, fmt.Errorf("DB error on certId %d key hash: %s", certId, err)
+	}
+
 	//
 	// Insert the raw certificate, if not already there
 	//
@@ -352,19 +448,28 @@ func (edb *EntriesDatabase) insertCertificate(cert *x509.Certificate) (*gorp.Tra
 		if err != nil {
 			return txn, certId, fmt.Errorf("DB error on raw certificate: %d: %s", certId, err)
 		}
+		if chain != nil {
+			err = edb.FullCerts.StoreChain(certId, chain.Bytes())
+			if err != nil {
+				return txn, certId, fmt.Errorf("DB error on certificate chain: %d: %s", certId, err)
+			}
+		}
 	}
 
 	//
 	// Process the DNS Names in the Certificate
 	//
 
-	// De-dupe the CN and the SAN
+	// De-dupe the CN and the SAN, unless this kind of certificate doesn't
+	// name hosts
 	names := make(map[string]struct{})
-	if cert.Subject.CommonName != "" {
-		names[cert.Subject.CommonName] = struct{}{}
-	}
-	for _, name := range cert.DNSNames {
-		names[name] = struct{}{}
+	if named && !edb.UnnamedPurposes[purpose] {
+		if cert.Subject.CommonName != "" {
+			names[cert.Subject.CommonName] = struct{}{}
+		}
+		for _, name := range cert.DNSNames {
+			names[name] = struct{}{}
+		}
 	}
 
 	// Loop and insert names into the DB
@@ -390,6 +495,16 @@ func (edb *EntriesDatabase) insertCertificate(cert *x509.Certificate) (*gorp.Tra
 		return txn, certId, fmt.Errorf("DB error on certId %d registered domains: %#v: %s", certId, names, err)
 	}
 
+	err = edb.insertSubject(txn, certId, cert)
+	if err != nil {
+		return txn, certId, fmt.Errorf("DB error on certId %d subject: %s", certId, err)
+	}
+
+	err = edb.insertEmbeddedSCTs(txn, certId, cert, chain.Issuer())
+	if err != nil {
+		return txn, certId, fmt.Errorf("DB error on certId %d embedded SCTs: %s", certId, err)
+	}
+
 	return txn, certId, nil
 }
 
@@ -495,7 +610,7 @@ func (edb *EntriesDatabase) insertRegisteredDomains(txn *gorp.Transaction, certI
 	return nil
 }
 
-func (edb *EntriesDatabase) certIsFilteredOut(cert *x509.Certificate) bool {
+func (edb *EntriesDatabase) certIsFilteredOut(cert *x509.Certificate, chain *StoredChain) bool {
 	// Skip unimportant entries, if configured
 
 	if !edb.EarliestDateFilter.IsZero() && cert.NotBefore.Before(edb.EarliestDateFilter) {
@@ -506,9 +621,14 @@ func (edb *EntriesDatabase) certIsFilteredOut(cert *x509.Certificate) bool {
 		return true
 	}
 
+	if edb.ExcludedPurposes[certPurpose(cert)] {
+		return true
+	}
+
+	_, issuerCommonName := issuerIdentity(cert, chain)
 	skip := (len(edb.IssuerCNFilter) != 0)
 	for _, filter := range edb.IssuerCNFilter {
-		if strings.HasPrefix(cert.Issuer.CommonName, filter) {
+		if strings.HasPrefix(issuerCommonName, filter) {
 			skip = false
 			break
 		}
@@ -521,17 +641,31 @@ func (edb *EntriesDatabase) certIsFilteredOut(cert *x509.Certificate) bool {
 	return skip
 }
 
-func (edb *EntriesDatabase) InsertCensysEntry(entry *censysdata.CensysEntry) error {
+func (edb *EntriesDatabase) InsertCensysEntry(ctx context.Context, entry *censysdata.CensysEntry) error {
 	cert, err := x509.ParseCertificate(entry.CertBytes)
 	if err != nil {
-		return err
+		return ParseError{err}
 	}
 
-	if edb.certIsFilteredOut(cert) {
+	if edb.certIsFilteredOut(cert, nil) {
+		return nil
+	}
+
+	// Already known, so just note the sighting
+	fingerprint := certFingerprint(cert)
+	certId, err := edb.findKnownCertificate(fingerprint)
+	if err != nil {
+		return err
+	}
+	if certId != 0 {
+		err = edb.DbMap.Insert(&CensysEntry{CertID: certId, EntryTime: *entry.Timestamp})
+		if errorIsNotDuplicate(err) {
+			return err
+		}
 		return nil
 	}
 
-	txn, certId, err := edb.insertCertificate(cert)
+	txn, certId, err := edb.insertCertificate(ctx, cert, fingerprint, nil, true)
 	if err != nil {
 		if edb.Verbose {
 			fmt.Printf("Error inserting cert, %s\n", err)
@@ -555,25 +689,66 @@ func (edb *EntriesDatabase) InsertCensysEntry(entry *censysdata.CensysEntry) err
 		return err
 	}
 
-	return txn.Commit()
+	if err = txn.Commit(); err != nil {
+		return err
+	}
+	edb.rememberCertificate(fingerprint)
+	return nil
 }
 
-func (edb *EntriesDatabase) InsertCTEntry(entry *ct.LogEntry, logID int) error {
+// InsertCTEntry adds the certificate in the log entry. Failed inserts are
+// retried a few times, unless ctx is done. Entries whose certificate can't be
+// parsed are kept in unparseable_entry instead.
+func (edb *EntriesDatabase) InsertCTEntry(ctx context.Context, entry *ct.LogEntry, logID int) error {
 	var cert *x509.Certificate
+	var der []byte
 	var err error
 
 	switch entry.Leaf.TimestampedEntry.EntryType {
 	case ct.X509LogEntryType:
-		cert, err = x509.ParseCertificate(entry.Leaf.TimestampedEntry.X509Entry)
+		der = entry.Leaf.TimestampedEntry.X509Entry
+		cert, err = x509.ParseCertificate(der)
 	case ct.PrecertLogEntryType:
-		cert, err = x509.ParseTBSCertificate(entry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate)
+		der = entry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate
+		cert, err = x509.ParseTBSCertificate(der)
+	default:
+		return LeafError{fmt.Errorf("unknown entry type: %v", entry.Leaf.TimestampedEntry.EntryType)}
 	}
 
 	if err != nil {
+		// Kept aside for reprocessing, rather than as a failure
+		if edb.Verbose {
+			log.Printf("Keeping unparseable entry: index: %d log: %d error: %s", entry.Index, logID, err)
+		}
+		return edb.recordUnparseable(entry, logID, der, err)
+	}
+
+	if err = edb.recordShardViolation(cert, logID, uint64(entry.Index)); err != nil {
+		if _, ok := err.(ShardError); ok {
+			return nil
+		}
 		return err
 	}
 
-	if edb.certIsFilteredOut(cert) {
+	chain := NewStoredChain(entry)
+	if edb.certIsFilteredOut(cert, chain) {
+		return nil
+	}
+
+	// Already known, so just note which log it's in
+	fingerprint := certFingerprint(cert)
+	knownID, err := edb.findKnownCertificate(fingerprint)
+	if err != nil {
+		return err
+	}
+	if knownID != 0 {
+		if !edb.CorrelateLogEntries {
+			return nil
+		}
+		err = edb.DbMap.Insert(newCertificateLogEntry(knownID, logID, entry))
+		if errorIsNotDuplicate(err) {
+			return err
+		}
 		return nil
 	}
 
@@ -582,7 +757,9 @@ func (edb *EntriesDatabase) InsertCTEntry(entry *ct.LogEntry, logID int) error {
 	}
 
 	for count := 0; count < 10; count++ {
-		txn, certID, err := edb.insertCertificate(cert)
+		var txn *gorp.Transaction
+		var certID uint64
+		txn, certID, err = edb.insertCertificate(ctx, cert, fingerprint, chain, true)
 		if err != nil {
 			if edb.Verbose {
 				fmt.Printf("Error inserting cert, retrying (%d/10) %s\n", count, err)
@@ -590,7 +767,11 @@ func (edb *EntriesDatabase) InsertCTEntry(entry *ct.LogEntry, logID int) error {
 			if txn != nil {
 				txn.Rollback()
 			}
-			time.Sleep(backoff.Duration())
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff.Duration()):
+			}
 			continue
 		}
 
@@ -598,25 +779,33 @@ func (edb *EntriesDatabase) InsertCTEntry(entry *ct.LogEntry, logID int) error {
 			//
 			// Insert the appropriate CertificateLogEntry, ignoring errors if there was a collision
 			//
-			certLogEntry := &CertificateLogEntry{
-				CertID:    certID,
-				LogID:     logID,
-				EntryID:   uint64(entry.Index),
-				EntryTime: utils.Uint64ToTimestamp(entry.Leaf.TimestampedEntry.Timestamp),
-			}
-			err = txn.Insert(certLogEntry)
+			err = txn.Insert(newCertificateLogEntry(certID, logID, entry))
 			if errorIsNotDuplicate(err) {
 				txn.Rollback()
 				continue
 			}
 		}
 
-		return txn.Commit()
+		if err = txn.Commit(); err != nil {
+			return err
+		}
+		edb.rememberCertificate(fingerprint)
+		return nil
 	}
 
 	return err
 }
 
+func newCertificateLogEntry(certID uint64, logID int, entry *ct.LogEntry) *CertificateLogEntry {
+	return &CertificateLogEntry{
+		CertID:    certID,
+		LogID:     logID,
+		EntryID:   uint64(entry.Index),
+		EntryTime: utils.Uint64ToTimestamp(entry.Leaf.TimestampedEntry.Timestamp),
+		EntryType: sql.NullInt64{Int64: int64(entry.Leaf.TimestampedEntry.EntryType), Valid: true},
+	}
+}
+
 func (edb *EntriesDatabase) InsertResolvedName(nameId uint64, address string) error {
 	obj := &ResolvedName{
 		NameID:  nameId,
@@ -0,0 +1,195 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Stores the subject distinguished name beyond the CN, and the organizations
+// named there, for reporting on OV and EV issuance
+
+package sqldb
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-gorp/gorp"
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/x509"
+)
+
+// Validation levels, from the CA/Browser Forum policy identifiers
+const (
+	ValidationDV = "dv"
+	ValidationOV = "ov"
+	ValidationIV = "iv"
+	ValidationEV = "ev"
+)
+
+var (
+	oidPolicyEV                    = asn1.ObjectIdentifier{2, 23, 140, 1, 1}
+	oidPolicyDV                    = asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}
+	oidPolicyOV                    = asn1.ObjectIdentifier{2, 23, 140, 1, 2, 2}
+	oidPolicyIV                    = asn1.ObjectIdentifier{2, 23, 140, 1, 2, 3}
+	oidBusinessCategory            = asn1.ObjectIdentifier{2, 5, 4, 15}
+	oidJurisdictionLocalityName    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 1}
+	oidJurisdictionStateOrProvince = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 2}
+	oidJurisdictionCountryName     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 3}
+)
+
+type Organization struct {
+	OrgID   uint64 `db:"orgID"`   // Internal Organization Identifier
+	Name    string `db:"name"`    // Subject O
+	Country string `db:"country"` // Subject C
+}
+
+type CertSubject struct {
+	CertID               uint64 `db:"certID"`               // Internal Cert Identifier (FK to Certificate)
+	OrgID                uint64 `db:"orgID"`                // Internal Organization Identifier, zero without an O
+	OrganizationalUnit   string `db:"organizationalUnit"`   // Subject OU
+	Country              string `db:"country"`              // Subject C
+	Province             string `db:"province"`             // Subject ST
+	Locality             string `db:"locality"`             // Subject L
+	SerialNumber         string `db:"serialNumber"`         // Subject serialNumber, the registration number for EV
+	BusinessCategory     string `db:"businessCategory"`     // Subject businessCategory
+	JurisdictionCountry  string `db:"jurisdictionCountry"`  // Subject jurisdictionOfIncorporationCountryName
+	JurisdictionProvince string `db:"jurisdictionProvince"` // Subject jurisdictionOfIncorporationStateOrProvinceName
+	JurisdictionLocality string `db:"jurisdictionLocality"` // Subject jurisdictionOfIncorporationLocalityName
+	Validation           string `db:"validation"`           // One of the Validation constants, or empty if not asserted
+}
+
+// Attributes that may appear more than once are stored joined by this
+const subjectValueSeparator = "; "
+
+// truncateValue shortens the value to the width, in characters, of the
+// varchar column it's stored in; the database runs in strict mode, where an
+// over-long value would fail the whole certificate's insert.
+func truncateValue(value string, width int) string {
+	if utf8.RuneCountInString(value) <= width {
+		return value
+	}
+	return string([]rune(value)[:width])
+}
+
+// validationLevel returns the validation level the certificate asserts with
+// a CA/Browser Forum policy identifier, or the empty string.
+func validationLevel(cert *x509.Certificate) string {
+	for _, policy := range cert.PolicyIdentifiers {
+		switch {
+		case policy.Equal(oidPolicyEV):
+			return ValidationEV
+		case policy.Equal(oidPolicyOV):
+			return ValidationOV
+		case policy.Equal(oidPolicyIV):
+			return ValidationIV
+		case policy.Equal(oidPolicyDV):
+			return ValidationDV
+		}
+	}
+	return ""
+}
+
+func newCertSubject(certId uint64, cert *x509.Certificate) *CertSubject {
+	subject := &CertSubject{
+		CertID:             certId,
+		OrganizationalUnit: strings.Join(cert.Subject.OrganizationalUnit, subjectValueSeparator),
+		Country:            strings.Join(cert.Subject.Country, subjectValueSeparator),
+		Province:           strings.Join(cert.Subject.Province, subjectValueSeparator),
+		Locality:           strings.Join(cert.Subject.Locality, subjectValueSeparator),
+		SerialNumber:       cert.Subject.SerialNumber,
+		Validation:         validationLevel(cert),
+	}
+
+	// pkix.Name leaves the EV attributes in Names
+	for _, attr := range cert.Subject.Names {
+		value, ok := attr.Value.(string)
+		if !ok {
+			continue
+		}
+		var field *string
+		switch {
+		case attr.Type.Equal(oidBusinessCategory):
+			field = &subject.BusinessCategory
+		case attr.Type.Equal(oidJurisdictionCountryName):
+			field = &subject.JurisdictionCountry
+		case attr.Type.Equal(oidJurisdictionStateOrProvince):
+			field = &subject.JurisdictionProvince
+		case attr.Type.Equal(oidJurisdictionLocalityName):
+			field = &subject.JurisdictionLocality
+		default:
+			continue
+		}
+		if len(*field) > 0 {
+			*field += subjectValueSeparator
+		}
+		*field += value
+	}
+
+	for _, column := range []struct {
+		value *string
+		width int
+	}{
+		{&subject.OrganizationalUnit, 255},
+		{&subject.Country, 64},
+		{&subject.Province, 128},
+		{&subject.Locality, 128},
+		{&subject.SerialNumber, 64},
+		{&subject.BusinessCategory, 128},
+		{&subject.JurisdictionCountry, 64},
+		{&subject.JurisdictionProvince, 128},
+		{&subject.JurisdictionLocality, 128},
+	} {
+		*column.value = truncateValue(*column.value, column.width)
+	}
+	return subject
+}
+
+func (edb *EntriesDatabase) getOrInsertOrganization(txn *gorp.Transaction, name, country string) (uint64, error) {
+	// Assume it doesn't exist, so let's try inserting it
+	orgObj := &Organization{
+		Name:    name,
+		Country: country,
+	}
+
+	err := txn.Insert(orgObj)
+	orgId := orgObj.OrgID
+	if err != nil {
+		if errorIsNotDuplicate(err) {
+			return 0, err
+		}
+
+		err = txn.SelectOne(&orgId, "SELECT orgID FROM organization WHERE name = ? AND country = ? LIMIT 1", name, country)
+		if err != nil {
+			return 0, fmt.Errorf("Unexpected error finding an Organization after getting an insertion error: %#v: %s", orgObj, err)
+		}
+	}
+
+	if orgId == 0 {
+		return 0, fmt.Errorf("Failed to obtain OrgID")
+	}
+	return orgId, nil
+}
+
+// insertSubject stores the certificate's subject attributes besides the CN.
+// Certificates with none, as most DV certificates are, aren't stored.
+func (edb *EntriesDatabase) insertSubject(txn *gorp.Transaction, certId uint64, cert *x509.Certificate) error {
+	subject := newCertSubject(certId, cert)
+	if len(cert.Subject.Organization) > 0 {
+		name := truncateValue(cert.Subject.Organization[0], 255)
+		orgId, err := edb.getOrInsertOrganization(txn, name, subject.Country)
+		if err != nil {
+			return fmt.Errorf("DB error on Organization: %s: %s", cert.Subject.Organization[0], err)
+		}
+		subject.OrgID = orgId
+	}
+
+	empty := CertSubject{CertID: certId, Validation: subject.Validation}
+	if *subject == empty {
+		return nil
+	}
+
+	err := txn.Insert(subject)
+	if errorIsNotDuplicate(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Records certificates we've submitted to logs, and what came of it
+
+package sqldb
+
+import (
+	"time"
+)
+
+// Outcomes of a submission
+const (
+	SubmissionAccepted     = "accepted"     // The log returned an SCT that verified
+	SubmissionBadSCT       = "bad_sct"      // The log returned an SCT that did not verify
+	SubmissionUnverifiable = "unverifiable" // The log returned an SCT we couldn't check
+	SubmissionRejected     = "rejected"     // The log refused the chain, or couldn't be reached
+)
+
+type Submission struct {
+	CertID      uint64    `db:"certID"`      // Internal Cert Identifier (FK to Certificate)
+	LogID       int       `db:"logID"`       // Log Identifier (FK to CertificateLog) submitted to
+	SubmittedAt time.Time `db:"submittedAt"` // Date of the submission
+	Outcome     string    `db:"outcome"`     // One of the Submission constants
+	SCTTime     time.Time `db:"sctTime"`     // Timestamp of the returned SCT, if any
+	Signature   []byte    `db:"signature"`   // Signature of the returned SCT, if any
+	Error       string    `db:"error"`       // Why the submission failed
+}
+
+// GetSubmissionCandidates returns unexpired certificates that are neither in
+// the log nor already accepted by it.
+func (edb *EntriesDatabase) GetSubmissionCandidates(logID int, limit uint64) ([]uint64, error) {
+	var certIDs []uint64
+	query := `SELECT u.certID FROM unexpired_certificate AS u
+		WHERE NOT EXISTS (SELECT 1 FROM ctlogentry AS e WHERE e.certID = u.certID AND e.logID = ?)
+		AND NOT EXISTS (SELECT 1 FROM submission AS s WHERE s.certID = u.certID AND s.logID = ? AND s.outcome = ?)
+		ORDER BY u.certID`
+	var err error
+	if limit > 0 {
+		_, err = edb.DbMap.Select(&certIDs, query+" LIMIT ?", logID, logID, SubmissionAccepted, limit)
+	} else {
+		_, err = edb.DbMap.Select(&certIDs, query, logID, logID, SubmissionAccepted)
+	}
+	return certIDs, err
+}
+
+func (edb *EntriesDatabase) RecordSubmission(obj *Submission) error {
+	return edb.DbMap.Insert(obj)
+}
@@ -0,0 +1,127 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Keeps the log entries whose certificates x509 couldn't parse, as they are
+// of interest in themselves, and inserts them later with a lenient parser
+
+package sqldb
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/certificate-transparency/go"
+	"github.com/jcjones/ct-sql/utils"
+	"golang.org/x/net/context"
+)
+
+type UnparseableEntry struct {
+	LogID      int       `db:"logID"`      // Log Identifier (FK to CertificateLog)
+	EntryID    uint64    `db:"entryID"`    // Index within the log
+	EntryTime  time.Time `db:"entryTime"`  // Leaf timestamp
+	EntryType  int       `db:"entryType"`  // RFC 6962 LogEntryType, 0 for certificates and 1 for precertificates
+	DER        []byte    `db:"der"`        // The DER certificate, or the TBS for precertificates
+	Chain      []byte    `db:"chain"`      // The entry's chain, as a StoredChain
+	ParseError string    `db:"parseError"` // Why x509 couldn't parse it
+	FirstSeen  time.Time `db:"firstSeen"`  // Date the entry was first found unparseable
+	CertID     uint64    `db:"certID"`     // Internal Cert Identifier once reprocessed, else zero
+}
+
+func (ue *UnparseableEntry) isPrecert() bool {
+	return ct.LogEntryType(ue.EntryType) == ct.PrecertLogEntryType
+}
+
+// recordUnparseable keeps the log entry whose certificate couldn't be parsed.
+// Seeing it again just updates the error.
+func (edb *EntriesDatabase) recordUnparseable(entry *ct.LogEntry, logID int, der []byte, parseErr error) error {
+	_, err := edb.DbMap.Exec(`INSERT INTO unparseable_entry
+		(logID, entryID, entryTime, entryType, der, chain, parseError, firstSeen, certID)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0)
+		ON DUPLICATE KEY UPDATE parseError = VALUES(parseError)`,
+		logID, entry.Index, utils.Uint64ToTimestamp(entry.Leaf.TimestampedEntry.Timestamp),
+		int(entry.Leaf.TimestampedEntry.EntryType), der, NewStoredChain(entry).Bytes(),
+		parseErr.Error(), time.Now())
+	return err
+}
+
+// GetUnparseableEntries returns the unparseable entries not yet reprocessed,
+// oldest first, leaving out those found outside their log's shard. A limit of
+// zero returns them all.
+func (edb *EntriesDatabase) GetUnparseableEntries(limit uint64) ([]UnparseableEntry, error) {
+	var entries []UnparseableEntry
+	query := `SELECT u.* FROM unparseable_entry AS u
+		WHERE u.certID = 0 AND NOT EXISTS (SELECT 1 FROM shard_violation AS v
+			WHERE v.logID = u.logID AND v.entryID = u.entryID)
+		ORDER BY u.firstSeen`
+	var err error
+	if limit > 0 {
+		_, err = edb.DbMap.Select(&entries, query+" LIMIT ?", limit)
+	} else {
+		_, err = edb.DbMap.Select(&entries, query)
+	}
+	return entries, err
+}
+
+// ReprocessUnparseableEntry inserts the entry's certificate as parsed by
+// ParseCertificateLeniently, and records the certID it was given. The entry
+// itself is kept. Filters like issuerCNList and earliestDate don't apply, as
+// this is only done on request. An entry outside its log's shard is recorded
+// as a ShardViolation instead, which handles it, leaving its certID zero.
+func (edb *EntriesDatabase) ReprocessUnparseableEntry(ctx context.Context, obj *UnparseableEntry) error {
+	cert, err := ParseCertificateLeniently(obj.DER, obj.isPrecert())
+	if err != nil {
+		return ParseError{err}
+	}
+
+	if err = edb.recordShardViolation(cert, obj.LogID, obj.EntryID); err != nil {
+		if _, ok := err.(ShardError); ok {
+			return nil
+		}
+		return err
+	}
+
+	var chain *StoredChain
+	if len(obj.Chain) > 0 {
+		if chain, err = ParseStoredChain(obj.Chain); err != nil {
+			return LeafError{err}
+		}
+	}
+
+	fingerprint := certFingerprint(cert)
+	txn, certID, err := edb.insertCertificate(ctx, cert, fingerprint, chain, true)
+	if err != nil {
+		if txn != nil {
+			txn.Rollback()
+		}
+		return err
+	}
+
+	if edb.CorrelateLogEntries {
+		err = txn.Insert(&CertificateLogEntry{
+			CertID:    certID,
+			LogID:     obj.LogID,
+			EntryID:   obj.EntryID,
+			EntryTime: obj.EntryTime,
+			EntryType: sql.NullInt64{Int64: int64(obj.EntryType), Valid: true},
+		})
+		if errorIsNotDuplicate(err) {
+			txn.Rollback()
+			return err
+		}
+	}
+
+	_, err = txn.Exec("UPDATE unparseable_entry SET certID = ? WHERE logID = ? AND entryID = ?",
+		certID, obj.LogID, obj.EntryID)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	if err = txn.Commit(); err != nil {
+		return err
+	}
+	obj.CertID = certID
+	edb.rememberCertificate(fingerprint)
+	return nil
+}
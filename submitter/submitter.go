@@ -0,0 +1,183 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Submits certificates to CT logs, and checks the SCTs they return
+
+package submitter
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/google/certificate-transparency/go"
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/jcjones/ct-sql/utils"
+	"golang.org/x/net/context"
+)
+
+// RFC 6962 Section 3.1
+var (
+	oidPoison                     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	oidPrecertSigningCertEKU      = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 4}
+	oidExtensionAuthorityKeyId    = asn1.ObjectIdentifier{2, 5, 29, 35}
+	errPrecertSigningCertNoIssuer = fmt.Errorf("Precertificate Signing Certificate chain has no CA")
+)
+
+// ChainAdder is the part of a log that accepts submissions;
+// *client.LogClient is one, and a fake log can stand in for it.
+type ChainAdder interface {
+	AddChain(ctx context.Context, chain []ct.ASN1Cert) (*ct.SignedCertificateTimestamp, error)
+	AddPreChain(ctx context.Context, chain []ct.ASN1Cert) (*ct.SignedCertificateTimestamp, error)
+}
+
+// RejectedError is returned when the log refused the submission outright
+type RejectedError struct {
+	Err error
+}
+
+func (e RejectedError) Error() string {
+	return fmt.Sprintf("log rejected submission: %s", e.Err)
+}
+
+// UnverifiableError is returned when the log returned an SCT, but the entry
+// it should be signed over couldn't be rebuilt from the chain to check it
+type UnverifiableError struct {
+	SCT *ct.SignedCertificateTimestamp
+	Err error
+}
+
+func (e UnverifiableError) Error() string {
+	return fmt.Sprintf("unable to verify SCT: %s", e.Err)
+}
+
+// BadSCTError is returned when the log's SCT didn't verify with its key
+type BadSCTError struct {
+	SCT *ct.SignedCertificateTimestamp
+	Err error
+}
+
+func (e BadSCTError) Error() string {
+	return fmt.Sprintf("SCT did not verify: %s", e.Err)
+}
+
+type Submitter struct {
+	Log      ChainAdder
+	Verifier *ct.SignatureVerifier
+}
+
+func New(log ChainAdder, verifier *ct.SignatureVerifier) *Submitter {
+	return &Submitter{
+		Log:      log,
+		Verifier: verifier,
+	}
+}
+
+// Submit sends a chain to the log and verifies the SCT it returns. For
+// certificates, chain starts with the certificate itself; for
+// precertificates, with the precertificate.
+func (s *Submitter) Submit(ctx context.Context, entryType ct.LogEntryType, chain []ct.ASN1Cert) (*ct.SignedCertificateTimestamp, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("Empty chain")
+	}
+
+	var sct *ct.SignedCertificateTimestamp
+	var err error
+	switch entryType {
+	case ct.X509LogEntryType:
+		sct, err = s.Log.AddChain(ctx, chain)
+	case ct.PrecertLogEntryType:
+		sct, err = s.Log.AddPreChain(ctx, chain)
+	default:
+		return nil, fmt.Errorf("Unsupported entry type: %v", entryType)
+	}
+	if err != nil {
+		return nil, RejectedError{err}
+	}
+
+	entry, err := signedEntry(entryType, chain, sct)
+	if err != nil {
+		return sct, UnverifiableError{SCT: sct, Err: err}
+	}
+	if err = s.Verifier.VerifySCTSignature(*sct, *entry); err != nil {
+		return sct, BadSCTError{SCT: sct, Err: err}
+	}
+	return sct, nil
+}
+
+// signedEntry rebuilds the log entry the SCT should have been signed over.
+// Precertificates issued by a Precertificate Signing Certificate are logged
+// as if the CA above it had issued them, per RFC 6962 Section 3.2.
+func signedEntry(entryType ct.LogEntryType, chain []ct.ASN1Cert, sct *ct.SignedCertificateTimestamp) (*ct.LogEntry, error) {
+	entry := &ct.LogEntry{
+		Leaf: ct.MerkleTreeLeaf{
+			Version:  ct.V1,
+			LeafType: ct.TimestampedEntryLeafType,
+			TimestampedEntry: ct.TimestampedEntry{
+				Timestamp:  sct.Timestamp,
+				EntryType:  entryType,
+				Extensions: sct.Extensions,
+			},
+		},
+	}
+
+	if entryType == ct.X509LogEntryType {
+		entry.Leaf.TimestampedEntry.X509Entry = chain[0]
+		return entry, nil
+	}
+
+	if len(chain) < 2 {
+		return nil, fmt.Errorf("Precertificate chain has no issuer")
+	}
+	precert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := x509.ParseCertificate(chain[1])
+	if err != nil {
+		return nil, err
+	}
+	tbs, err := utils.RemoveExtension(precert.RawTBSCertificate, oidPoison)
+	if err != nil {
+		return nil, err
+	}
+
+	if isPrecertSigningCert(issuer) {
+		if len(chain) < 3 {
+			return nil, errPrecertSigningCertNoIssuer
+		}
+		ca, err := x509.ParseCertificate(chain[2])
+		if err != nil {
+			return nil, err
+		}
+
+		var akiExt []byte
+		for _, ext := range issuer.Extensions {
+			if ext.Id.Equal(oidExtensionAuthorityKeyId) {
+				if akiExt, err = asn1.Marshal(ext); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if tbs, err = utils.ReplaceIssuer(tbs, issuer.RawIssuer, oidExtensionAuthorityKeyId, akiExt); err != nil {
+			return nil, err
+		}
+		issuer = ca
+	}
+
+	entry.Leaf.TimestampedEntry.PrecertEntry = ct.PreCert{
+		IssuerKeyHash:  sha256.Sum256(issuer.RawSubjectPublicKeyInfo),
+		TBSCertificate: tbs,
+	}
+	return entry, nil
+}
+
+func isPrecertSigningCert(cert *x509.Certificate) bool {
+	for _, eku := range cert.UnknownExtKeyUsage {
+		if eku.Equal(oidPrecertSigningCertEKU) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package submitter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency/go"
+	"github.com/google/certificate-transparency/go/asn1"
+	"github.com/google/certificate-transparency/go/tls"
+	"github.com/google/certificate-transparency/go/x509"
+	"github.com/google/certificate-transparency/go/x509/pkix"
+	"golang.org/x/net/context"
+)
+
+// fakeLog signs SCTs in-process with its own key. Like a real log, it signs
+// precertificates over the TBS the final certificate will have, and the key
+// of the CA that will issue it, which the test tells it.
+type fakeLog struct {
+	key        *ecdsa.PrivateKey
+	precertTBS []byte
+	issuerKey  []byte
+	reject     error
+}
+
+func (fl *fakeLog) sign(entry ct.LogEntry) (*ct.SignedCertificateTimestamp, error) {
+	if fl.reject != nil {
+		return nil, fl.reject
+	}
+	sct := &ct.SignedCertificateTimestamp{
+		SCTVersion: ct.V1,
+		Timestamp:  uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+	}
+	data, err := ct.SerializeSCTSignatureInput(*sct, entry)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := tls.CreateSignature(*fl.key, tls.SHA256, data)
+	if err != nil {
+		return nil, err
+	}
+	sct.Signature = ct.DigitallySigned(sig)
+	return sct, nil
+}
+
+func (fl *fakeLog) AddChain(ctx context.Context, chain []ct.ASN1Cert) (*ct.SignedCertificateTimestamp, error) {
+	var entry ct.LogEntry
+	entry.Leaf.TimestampedEntry.EntryType = ct.X509LogEntryType
+	entry.Leaf.TimestampedEntry.X509Entry = chain[0]
+	return fl.sign(entry)
+}
+
+func (fl *fakeLog) AddPreChain(ctx context.Context, chain []ct.ASN1Cert) (*ct.SignedCertificateTimestamp, error) {
+	var entry ct.LogEntry
+	entry.Leaf.TimestampedEntry.EntryType = ct.PrecertLogEntryType
+	entry.Leaf.TimestampedEntry.PrecertEntry = ct.PreCert{
+		IssuerKeyHash:  sha256.Sum256(fl.issuerKey),
+		TBSCertificate: fl.precertTBS,
+	}
+	return fl.sign(entry)
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testCA is a certificate along with its key, able to issue others
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func issue(t *testing.T, template *x509.Certificate, pub *ecdsa.PublicKey, parent *testCA) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent.cert, pub, parent.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newCA(t *testing.T, name string, parent *testCA, usages []x509.ExtKeyUsage, unknownUsages ...asn1.ObjectIdentifier) *testCA {
+	key := newKey(t)
+	keyID := sha256.Sum256([]byte(name))
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           usages,
+		UnknownExtKeyUsage:    unknownUsages,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          keyID[:20],
+	}
+	if parent == nil {
+		// Self-signed
+		parent = &testCA{cert: template, key: key}
+	}
+	return &testCA{cert: issue(t, template, &key.PublicKey, parent), key: key}
+}
+
+func leafTemplate(precert bool) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2017, 4, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if precert {
+		template.ExtraExtensions = []pkix.Extension{{Id: oidPoison, Critical: true, Value: []byte{0x05, 0x00}}}
+	}
+	return template
+}
+
+func chainOf(certs ...*x509.Certificate) []ct.ASN1Cert {
+	chain := make([]ct.ASN1Cert, len(certs))
+	for i, cert := range certs {
+		chain[i] = cert.Raw
+	}
+	return chain
+}
+
+func newSubmitter(t *testing.T, log *fakeLog) *Submitter {
+	verifier, err := ct.NewSignatureVerifier(&log.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(log, verifier)
+}
+
+func TestSubmitAccepted(t *testing.T) {
+	ca := newCA(t, "Test CA", nil, nil)
+	leafKey := newKey(t)
+	leaf := issue(t, leafTemplate(false), &leafKey.PublicKey, ca)
+
+	s := newSubmitter(t, &fakeLog{key: newKey(t)})
+	sct, err := s.Submit(context.Background(), ct.X509LogEntryType, chainOf(leaf, ca.cert))
+	if err != nil {
+		t.Fatalf("Expected the SCT to verify, got %s", err)
+	}
+	if sct == nil {
+		t.Fatalf("Expected an SCT")
+	}
+}
+
+func TestSubmitPrecertAccepted(t *testing.T) {
+	ca := newCA(t, "Test CA", nil, nil)
+	leafKey := newKey(t)
+	precert := issue(t, leafTemplate(true), &leafKey.PublicKey, ca)
+	final := issue(t, leafTemplate(false), &leafKey.PublicKey, ca)
+
+	log := &fakeLog{key: newKey(t), precertTBS: final.RawTBSCertificate, issuerKey: ca.cert.RawSubjectPublicKeyInfo}
+	_, err := newSubmitter(t, log).Submit(context.Background(), ct.PrecertLogEntryType, chainOf(precert, ca.cert))
+	if err != nil {
+		t.Fatalf("Expected the SCT to verify, got %s", err)
+	}
+}
+
+func TestSubmitPrecertSigningCert(t *testing.T) {
+	ca := newCA(t, "Test CA", nil, nil)
+	signer := newCA(t, "Test Precertificate Signer", ca, nil, oidPrecertSigningCertEKU)
+	leafKey := newKey(t)
+	precert := issue(t, leafTemplate(true), &leafKey.PublicKey, signer)
+	final := issue(t, leafTemplate(false), &leafKey.PublicKey, ca)
+
+	// Logged as though the CA had issued it
+	log := &fakeLog{key: newKey(t), precertTBS: final.RawTBSCertificate, issuerKey: ca.cert.RawSubjectPublicKeyInfo}
+	s := newSubmitter(t, log)
+	_, err := s.Submit(context.Background(), ct.PrecertLogEntryType, chainOf(precert, signer.cert, ca.cert))
+	if err != nil {
+		t.Fatalf("Expected the SCT to verify, got %s", err)
+	}
+
+	_, err = s.Submit(context.Background(), ct.PrecertLogEntryType, chainOf(precert, signer.cert))
+	if _, ok := err.(UnverifiableError); !ok {
+		t.Fatalf("Expected an UnverifiableError without the CA, got %v", err)
+	}
+}
+
+func TestSubmitRejected(t *testing.T) {
+	ca := newCA(t, "Test CA", nil, nil)
+	leafKey := newKey(t)
+	leaf := issue(t, leafTemplate(false), &leafKey.PublicKey, ca)
+
+	log := &fakeLog{key: newKey(t), reject: fmt.Errorf("unknown root")}
+	sct, err := newSubmitter(t, log).Submit(context.Background(), ct.X509LogEntryType, chainOf(leaf, ca.cert))
+	if _, ok := err.(RejectedError); !ok {
+		t.Fatalf("Expected a RejectedError, got %v", err)
+	}
+	if sct != nil {
+		t.Fatalf("Expected no SCT from a rejection")
+	}
+}
+
+func TestSubmitBadSCT(t *testing.T) {
+	ca := newCA(t, "Test CA", nil, nil)
+	leafKey := newKey(t)
+	leaf := issue(t, leafTemplate(false), &leafKey.PublicKey, ca)
+
+	// Verified against a key other than the one the log signs with
+	log := &fakeLog{key: newKey(t)}
+	verifier, err := ct.NewSignatureVerifier(&newKey(t).PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sct, err := New(log, verifier).Submit(context.Background(), ct.X509LogEntryType, chainOf(leaf, ca.cert))
+	if _, ok := err.(BadSCTError); !ok {
+		t.Fatalf("Expected a BadSCTError, got %v", err)
+	}
+	if sct == nil {
+		t.Fatalf("Expected the bad SCT to be returned")
+	}
+}
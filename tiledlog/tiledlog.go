@@ -0,0 +1,407 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Reads logs published via the Static CT API (https://c2sp.org/static-ct-api)
+
+package tiledlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/certificate-transparency/go"
+	"github.com/google/certificate-transparency/go/client"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// TileWidth is the number of entries in a full data tile
+const TileWidth = 256
+
+// Extension type of the leaf_index CtExtension
+const leafIndexExtensionType = 0
+
+type Checkpoint struct {
+	Origin    string        // The log's origin line, also its key name
+	TreeSize  uint64        // Number of entries in the tree
+	RootHash  ct.SHA256Hash // Root hash of the tree
+	Timestamp uint64        // Milliseconds since the epoch, from the signature
+}
+
+// HTTPError is returned when the log answers with anything other than 200 OK
+type HTTPError struct {
+	StatusCode int
+	RetryAfter string // The raw Retry-After header, if any
+	URL        string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("got HTTP Status %d from %s", e.StatusCode, e.URL)
+}
+
+type TiledLog struct {
+	prefix     string
+	logID      ct.SHA256Hash
+	verifier   *ct.SignatureVerifier
+	httpClient *http.Client
+	issuers    map[[sha256.Size]byte][]byte
+	issuerLock sync.RWMutex
+}
+
+// New constructs a reader for the log whose monitoring prefix is uri, using
+// the PEM-encoded public key to verify its checkpoints.
+func New(uri string, publicKeyPEM []byte) (*TiledLog, error) {
+	pubKey, logID, _, err := ct.PublicKeyFromPEM(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := ct.NewSignatureVerifier(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return &TiledLog{
+		prefix:   strings.TrimRight(uri, "/"),
+		logID:    logID,
+		verifier: verifier,
+		httpClient: &http.Client{
+			Timeout: time.Second * 30,
+		},
+		issuers: make(map[[sha256.Size]byte][]byte),
+	}, nil
+}
+
+func (tl *TiledLog) String() string {
+	return fmt.Sprintf("Tiled Log (URL=%s)", tl.prefix)
+}
+
+func (tl *TiledLog) get(ctx context.Context, path string) ([]byte, error) {
+	uri := fmt.Sprintf("%s/%s", tl.prefix, path)
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ctxhttp.Do(ctx, tl.httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: resp.Header.Get("Retry-After"),
+			URL:        uri,
+		}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// keyID is the note key ID of an RFC6962NoteSignature: the first four bytes
+// of SHA-256(key name || 0x0A || 0x05 || log ID).
+func (tl *TiledLog) keyID(name string) []byte {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{0x0A, 0x05})
+	h.Write(tl.logID[:])
+	return h.Sum(nil)[:4]
+}
+
+// GetCheckpoint fetches the log's checkpoint and verifies its signature.
+func (tl *TiledLog) GetCheckpoint(ctx context.Context) (*Checkpoint, error) {
+	data, err := tl.get(ctx, "checkpoint")
+	if err != nil {
+		return nil, err
+	}
+	return tl.parseCheckpoint(data)
+}
+
+func (tl *TiledLog) parseCheckpoint(data []byte) (*Checkpoint, error) {
+	parts := strings.SplitN(string(data), "\n\n", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Malformed checkpoint: no signature block")
+	}
+
+	lines := strings.Split(parts[0], "\n")
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("Malformed checkpoint: expected at least 3 lines, got %d", len(lines))
+	}
+
+	treeSize, err := strconv.ParseUint(lines[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed checkpoint tree size: %s", err)
+	}
+	checkpoint := &Checkpoint{
+		Origin:   lines[0],
+		TreeSize: treeSize,
+	}
+	if err := checkpoint.RootHash.FromBase64String(lines[2]); err != nil {
+		return nil, fmt.Errorf("Malformed checkpoint root hash: %s", err)
+	}
+
+	expectedKeyID := tl.keyID(checkpoint.Origin)
+	for _, sigLine := range strings.Split(parts[1], "\n") {
+		fields := strings.Fields(strings.TrimPrefix(sigLine, "— "))
+		if !strings.HasPrefix(sigLine, "— ") || len(fields) != 2 || fields[0] != checkpoint.Origin {
+			continue
+		}
+
+		sig, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(sig) < 12 || !bytes.Equal(sig[:4], expectedKeyID) {
+			continue
+		}
+
+		// The signature is the STH timestamp followed by a TLS
+		// DigitallySigned over the RFC 6962 tree head.
+		checkpoint.Timestamp = binary.BigEndian.Uint64(sig[4:12])
+		ds, err := ct.UnmarshalDigitallySigned(bytes.NewReader(sig[12:]))
+		if err != nil {
+			return nil, fmt.Errorf("Malformed checkpoint signature: %s", err)
+		}
+
+		sth := ct.SignedTreeHead{
+			Version:           ct.V1,
+			TreeSize:          checkpoint.TreeSize,
+			Timestamp:         checkpoint.Timestamp,
+			SHA256RootHash:    checkpoint.RootHash,
+			TreeHeadSignature: *ds,
+		}
+		if err := tl.verifier.VerifySTHSignature(sth); err != nil {
+			return nil, fmt.Errorf("Checkpoint signature did not verify: %s", err)
+		}
+		return checkpoint, nil
+	}
+
+	return nil, fmt.Errorf("No signature on checkpoint from log %s", tl.logID.Base64String())
+}
+
+// tilePath encodes a tile index as groups of three digits, all but the last
+// prefixed with x, e.g. 1234067 is x001/x234/067.
+func tilePath(index uint64) string {
+	digits := fmt.Sprintf("%d", index)
+	for len(digits)%3 != 0 {
+		digits = "0" + digits
+	}
+	var groups []string
+	for i := 0; i < len(digits); i += 3 {
+		groups = append(groups, digits[i:i+3])
+	}
+	for i := 0; i < len(groups)-1; i++ {
+		groups[i] = "x" + groups[i]
+	}
+	return strings.Join(groups, "/")
+}
+
+// GetRawEntries returns entries [start, end] of a tree of the given size,
+// re-encoded as RFC 6962 leaf_input and extra_data so that they can be handled
+// exactly like get-entries results.
+func (tl *TiledLog) GetRawEntries(ctx context.Context, treeSize uint64, start, end uint64) ([]client.LeafEntry, error) {
+	if end < start {
+		return nil, fmt.Errorf("start should be <= end")
+	}
+	if end >= treeSize {
+		return nil, fmt.Errorf("end %d is beyond the tree size %d", end, treeSize)
+	}
+
+	var entries []client.LeafEntry
+	for tile := start / TileWidth; tile <= end/TileWidth; tile++ {
+		path := "tile/data/" + tilePath(tile)
+		width := treeSize - tile*TileWidth
+		if width < TileWidth {
+			path = fmt.Sprintf("%s.p/%d", path, width)
+		} else {
+			width = TileWidth
+		}
+
+		data, err := tl.get(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+
+		leaves, err := tl.parseEntryBundle(ctx, tile*TileWidth, data)
+		if err != nil {
+			return nil, fmt.Errorf("Tile %d: %s", tile, err)
+		}
+		if uint64(len(leaves)) != width {
+			return nil, fmt.Errorf("Tile %d: expected %d entries, got %d", tile, width, len(leaves))
+		}
+
+		for i, leaf := range leaves {
+			index := tile*TileWidth + uint64(i)
+			if index >= start && index <= end {
+				entries = append(entries, leaf)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// parseEntryBundle decodes the concatenated TileLeaf structures of a data
+// tile whose first entry is at index firstIndex.
+func (tl *TiledLog) parseEntryBundle(ctx context.Context, firstIndex uint64, data []byte) ([]client.LeafEntry, error) {
+	var leaves []client.LeafEntry
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		leaf, err := tl.parseTileLeaf(ctx, firstIndex+uint64(len(leaves)), data, reader)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %s", firstIndex+uint64(len(leaves)), err)
+		}
+		leaves = append(leaves, *leaf)
+	}
+	return leaves, nil
+}
+
+// parseTileLeaf reads one TileLeaf:
+//
+//	struct {
+//	    TimestampedEntry timestamped_entry;
+//	    select (entry_type) {
+//	        case x509_entry: Empty;
+//	        case precert_entry: ASN.1Cert pre_certificate;
+//	    };
+//	    Fingerprint certificate_chain<0..2^16-1>;
+//	} TileLeaf;
+func (tl *TiledLog) parseTileLeaf(ctx context.Context, index uint64, data []byte, reader *bytes.Reader) (*client.LeafEntry, error) {
+	offset := len(data) - reader.Len()
+	var entry ct.TimestampedEntry
+	if err := ct.ReadTimestampedEntryInto(reader, &entry); err != nil {
+		return nil, err
+	}
+
+	// The RFC 6962 leaf_input is a v1 timestamped_entry MerkleTreeLeaf,
+	// which is exactly the bytes we just read, behind a two byte header.
+	leafInput := []byte{byte(ct.V1), byte(ct.TimestampedEntryLeafType)}
+	leafInput = append(leafInput, data[offset:len(data)-reader.Len()]...)
+
+	if err := checkLeafIndex(entry.Extensions, index); err != nil {
+		return nil, err
+	}
+
+	var preCertificate []byte
+	if entry.EntryType == ct.PrecertLogEntryType {
+		var err error
+		if preCertificate, err = readVarBytes(reader, 3); err != nil {
+			return nil, fmt.Errorf("pre_certificate: %s", err)
+		}
+	}
+
+	fingerprints, err := readVarBytes(reader, 2)
+	if err != nil {
+		return nil, fmt.Errorf("certificate_chain: %s", err)
+	}
+	if len(fingerprints)%sha256.Size != 0 {
+		return nil, fmt.Errorf("certificate_chain length %d is not a multiple of %d", len(fingerprints), sha256.Size)
+	}
+
+	var chain bytes.Buffer
+	for i := 0; i < len(fingerprints); i += sha256.Size {
+		var fingerprint [sha256.Size]byte
+		copy(fingerprint[:], fingerprints[i:i+sha256.Size])
+		issuer, err := tl.getIssuer(ctx, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		writeVarBytes(&chain, issuer, 3)
+	}
+
+	var extraData bytes.Buffer
+	if entry.EntryType == ct.PrecertLogEntryType {
+		writeVarBytes(&extraData, preCertificate, 3)
+	}
+	writeVarBytes(&extraData, chain.Bytes(), 3)
+
+	return &client.LeafEntry{
+		LeafInput: leafInput,
+		ExtraData: extraData.Bytes(),
+	}, nil
+}
+
+// checkLeafIndex confirms the leaf_index extension, if present, matches the
+// position of the entry in the tile.
+func checkLeafIndex(extensions []byte, index uint64) error {
+	reader := bytes.NewReader(extensions)
+	for reader.Len() > 0 {
+		extType, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		data, err := readVarBytes(reader, 2)
+		if err != nil {
+			return fmt.Errorf("extensions: %s", err)
+		}
+		if extType != leafIndexExtensionType {
+			continue
+		}
+		if len(data) != 5 {
+			return fmt.Errorf("leaf_index extension is %d bytes, expected 5", len(data))
+		}
+		var leafIndex uint64
+		for _, b := range data {
+			leafIndex = leafIndex<<8 | uint64(b)
+		}
+		if leafIndex != index {
+			return fmt.Errorf("leaf_index %d does not match position %d", leafIndex, index)
+		}
+	}
+	return nil
+}
+
+// getIssuer fetches a chain certificate by its SHA-256 fingerprint, caching
+// it, since a handful of issuers cover nearly every entry.
+func (tl *TiledLog) getIssuer(ctx context.Context, fingerprint [sha256.Size]byte) ([]byte, error) {
+	tl.issuerLock.RLock()
+	issuer, ok := tl.issuers[fingerprint]
+	tl.issuerLock.RUnlock()
+	if ok {
+		return issuer, nil
+	}
+
+	issuer, err := tl.get(ctx, "issuer/"+hex.EncodeToString(fingerprint[:]))
+	if err != nil {
+		return nil, err
+	}
+	if sha256.Sum256(issuer) != fingerprint {
+		return nil, fmt.Errorf("issuer %x does not match its fingerprint", fingerprint)
+	}
+
+	tl.issuerLock.Lock()
+	tl.issuers[fingerprint] = issuer
+	tl.issuerLock.Unlock()
+	return issuer, nil
+}
+
+func readVarBytes(reader io.Reader, lenBytes int) ([]byte, error) {
+	var length uint64
+	lenBuf := make([]byte, lenBytes)
+	if _, err := io.ReadFull(reader, lenBuf); err != nil {
+		return nil, err
+	}
+	for _, b := range lenBuf {
+		length = length<<8 | uint64(b)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("short read: expected %d bytes", length)
+	}
+	return data, nil
+}
+
+func writeVarBytes(w *bytes.Buffer, data []byte, lenBytes int) {
+	length := uint64(len(data))
+	for i := lenBytes - 1; i >= 0; i-- {
+		w.WriteByte(byte(length >> (8 * uint(i))))
+	}
+	w.Write(data)
+}
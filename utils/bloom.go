@@ -0,0 +1,72 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package utils
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// BloomFilter is a set membership filter over cryptographic digests, which
+// are uniformly distributed enough to index it directly. It may report a
+// digest it never saw as present, but never the reverse.
+type BloomFilter struct {
+	bits   []uint64
+	m      uint64
+	hashes int
+	lock   sync.RWMutex
+}
+
+// NewBloomFilter sizes a filter for expected digests at a 1% false positive
+// rate.
+func NewBloomFilter(expected uint64) *BloomFilter {
+	if expected < 1 {
+		expected = 1
+	}
+	m := uint64(math.Ceil(-float64(expected) * math.Log(0.01) / (math.Ln2 * math.Ln2)))
+	return &BloomFilter{
+		bits:   make([]uint64, (m+63)/64),
+		m:      m,
+		hashes: 7,
+	}
+}
+
+// positions derives the filter positions of a digest, by double hashing with
+// its first two 64-bit words.
+func (bf *BloomFilter) positions(digest []byte) []uint64 {
+	var buf [16]byte
+	copy(buf[:], digest)
+	h1 := binary.BigEndian.Uint64(buf[0:8])
+	h2 := binary.BigEndian.Uint64(buf[8:16])
+
+	positions := make([]uint64, bf.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % bf.m
+	}
+	return positions
+}
+
+func (bf *BloomFilter) Add(digest []byte) {
+	positions := bf.positions(digest)
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+	for _, pos := range positions {
+		bf.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+// MayContain is false only if the digest was never added.
+func (bf *BloomFilter) MayContain(digest []byte) bool {
+	positions := bf.positions(digest)
+	bf.lock.RLock()
+	defer bf.lock.RUnlock()
+	for _, pos := range positions {
+		if bf.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
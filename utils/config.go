@@ -12,6 +12,8 @@ import (
 type CTConfig struct {
 	LogUrl              *string
 	LogUrlList          *string
+	LogKey              *string
+	LogOperator         *string
 	CensysPath          *string
 	CensysUrl           *string
 	CensysStdin         *bool
@@ -32,12 +34,48 @@ type CTConfig struct {
 	EarliestDateFilter  *string
 	CorrelateLogEntries *bool
 	LogExpiredEntries   *bool
+	LogRateLimit        *float64
+	LogRateBurst        *int
+	MaxRetries          *int
+	CheckpointInterval  *int
+	RetryFailed         *bool
+	Backfill            *bool
+	TiledLogUrl         *string
+	TiledLogKey         *string
+	ArchivePath         *string
+	FromArchive         *bool
+	CTPolicy            *string
+	CTPolicyOperators   *int
+	HealthWindow        *int
+	MaxErrorRate        *float64
+	MinPollingDelay     *int
+	MaxDownloads        *int
+	LogShard            *string
+	LogListJson         *string
+	LeaseSize           *uint64
+	LeaseDuration       *int
+	ShutdownTimeout     *int
+	KnownCertFilter     *uint64
+	LatestDateFilter    *string
+	OutputFormat        *string
+	ReportPeriod        *string
+	ReprocessUnparsed   *bool
+	BackfillSPKI        *bool
+	BackfillFingerprint *bool
+	ExcludePurposes     *string
+	ExcludeNamePurposes *string
+	KeyReuseCerts       *int
+	KeyReuseDomains     *int
+	DebianWeakKeys      *string
+	BatchGCDSize        *int
 }
 
 func NewCTConfig() *CTConfig {
 	ret := &CTConfig{
 		LogUrl:              flag.String("log", "", "URL of the CT Log"),
 		LogUrlList:          flag.String("logList", "", "URLs of the CT Logs, comma delimited"),
+		LogKey:              flag.String("logKey", "", "Path to the PEM public key of the CT Log given by log, to attribute and verify its SCTs"),
+		LogOperator:         flag.String("logOperator", "", "Operator of the CT Logs given by log and tiledLog, for CT policy compliance; needs their keys"),
 		CensysPath:          flag.String("censysJson", "", "Path to a Censys.io certificate json dump"),
 		CensysUrl:           flag.String("censysUrl", "", "URL to a Censys.io certificate json dump"),
 		CensysStdin:         flag.Bool("censysStdin", false, "Read a Censys.io json dump from stdin"),
@@ -53,11 +91,45 @@ func NewCTConfig() *CTConfig {
 		NumThreads:          flag.Int("numThreads", 1, "Use this many threads per CPU"),
 		HistoricalDays:      flag.Int("histDays", 90, "Update this many days of historical data"),
 		RunForever:          flag.Bool("forever", false, "Run forever"),
-		PollingDelay:        flag.Int("pollingDelay", 10, "Wait this many minutes between polls"),
+		PollingDelay:        flag.Int("pollingDelay", 10, "Wait at most this many minutes between polls of a log"),
 		IssuerCNFilter:      flag.String("issuerCNList", "", "Prefixes to match for CNs for permitted issuers, comma delimited"),
 		EarliestDateFilter:  flag.String("earliestDate", "", "Datestamp (YYYY-MM-DD) of the earliest date to accept"),
 		CorrelateLogEntries: flag.Bool("correlateLogEntries", false, "Maintain a list of what certificates were found in which logs"),
 		LogExpiredEntries:   flag.Bool("logExpiredEntries", false, "Add expired entries to the database"),
+		LogRateLimit:        flag.Float64("logRateLimit", 0, "Maximum requests per second to each CT log, 0 for unlimited"),
+		LogRateBurst:        flag.Int("logRateBurst", 1, "Requests permitted in a burst above logRateLimit"),
+		MaxRetries:          flag.Int("maxRetries", 10, "Retry transient CT log errors this many times before halting"),
+		CheckpointInterval:  flag.Int("checkpointInterval", 60, "Save download progress to the database every this many seconds"),
+		RetryFailed:         flag.Bool("retryFailed", false, "Retry inserting entries that previously failed, up to limit"),
+		Backfill:            flag.Bool("backfill", false, "Download only the missing ranges of each log; needs correlateLogEntries"),
+		TiledLogUrl:         flag.String("tiledLog", "", "Monitoring URL prefix of a static-ct-api (tiled) CT Log"),
+		TiledLogKey:         flag.String("tiledLogKey", "", "Path to the PEM public key of the tiled CT Log"),
+		ArchivePath:         flag.String("archivePath", "", "Path under which to archive raw CT log entries as they are downloaded"),
+		FromArchive:         flag.Bool("fromArchive", false, "Re-ingest the entries under archivePath instead of downloading"),
+		CTPolicy:            flag.String("ctPolicy", "180:2,3", "SCTs required by certificate lifetime, as days:scts pairs ending with the count for longer lifetimes"),
+		CTPolicyOperators:   flag.Int("ctPolicyOperators", 2, "Distinct log operators required by the CT policy"),
+		HealthWindow:        flag.Int("healthWindow", 24, "Report on log health recorded in this many past hours"),
+		MaxErrorRate:        flag.Float64("maxErrorRate", 0.05, "Fraction of failed calls above which a log is reported unhealthy"),
+		MinPollingDelay:     flag.Int("minPollingDelay", 30, "Wait at least this many seconds between polls of a log"),
+		LogShard:            flag.String("logShard", "", "Expiry range (YYYY-MM-DD:YYYY-MM-DD, end exclusive) of the certificates in the CT Logs given by log and tiledLog"),
+		LogListJson:         flag.String("logListJson", "", "Path to a browser log list (log_list.json v3), whose logs are downloaded with their keys, operators and shards"),
+		LeaseSize:           flag.Uint64("leaseSize", 0, "Lease log ranges of this many entries, so several processes can download one log; 0 disables leasing"),
+		LeaseDuration:       flag.Int("leaseDuration", 300, "Seconds a lease is held without progress before another process may take it over"),
+		ShutdownTimeout:     flag.Int("shutdownTimeout", 60, "Seconds to finish in-flight work and save state after SIGINT or SIGTERM before exiting regardless"),
+		KnownCertFilter:     flag.Uint64("knownCertFilter", 0, "Size a filter of known certificates for about this many, so they skip re-insertion; 0 disables"),
+		LatestDateFilter:    flag.String("latestDate", "", "Datestamp (YYYY-MM-DD) before which to report, defaulting to now"),
+		OutputFormat:        flag.String("format", "csv", "Report output format, csv or json"),
+		ReportPeriod:        flag.String("period", "month", "Period of notBefore by which to break down reports by issuer, week or month"),
+		ReprocessUnparsed:   flag.Bool("reprocessUnparseable", false, "Insert the log entries x509 couldn't parse using a lenient parser, up to limit"),
+		BackfillSPKI:        flag.Bool("backfillSPKI", false, "Index the keys of stored certificates inserted before keys were indexed, up to limit; needs certPath"),
+		BackfillFingerprint: flag.Bool("backfillFingerprints", false, "Record the fingerprints of stored certificates inserted before they were kept, for knownCertFilter, up to limit; needs certPath"),
+		ExcludePurposes:     flag.String("excludePurposes", "", "Certificate purposes not to add to the database, comma delimited from ca, tls-server, tls-client, smime, code-signing, other"),
+		ExcludeNamePurposes: flag.String("excludeNamePurposes", "", "Certificate purposes whose names aren't added as FQDNs, comma delimited"),
+		KeyReuseCerts:       flag.Int("keyReuseCerts", 10, "Report keys used by more than this many certificates"),
+		KeyReuseDomains:     flag.Int("keyReuseDomains", 1, "Report keys whose certificates name more than this many registered domains"),
+		DebianWeakKeys:      flag.String("debianWeakKeys", "", "Paths to Debian weak key blocklists (openssl-blacklist format), comma delimited"),
+		BatchGCDSize:        flag.Int("batchGCDSize", 100000, "Check keys for shared factors in groups of at most this many moduli, bounding memory; 0 for one group"),
+		MaxDownloads:        flag.Int("maxDownloads", 0, "Download at most this many logs at once when running forever, 0 for unlimited"),
 	}
 
 	iniflags.Parse()
@@ -0,0 +1,122 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/certificate-transparency/go/asn1"
+)
+
+// RemoveExtension re-encodes a DER TBSCertificate without the extension
+// identified by oid.
+func RemoveExtension(tbs []byte, oid asn1.ObjectIdentifier) ([]byte, error) {
+	return editTBS(tbs, nil, func(extID asn1.ObjectIdentifier, ext []byte) []byte {
+		if extID.Equal(oid) {
+			return nil
+		}
+		return ext
+	})
+}
+
+// ReplaceIssuer re-encodes a DER TBSCertificate with rawIssuer as its issuer
+// name, and with its extension identified by oid, if it has one, replaced by
+// ext, a DER Extension; a nil ext removes it. This is how RFC 6962 Section
+// 3.2 rebuilds precertificates issued by a Precertificate Signing Certificate.
+func ReplaceIssuer(tbs []byte, rawIssuer []byte, oid asn1.ObjectIdentifier, ext []byte) ([]byte, error) {
+	return editTBS(tbs, rawIssuer, func(extID asn1.ObjectIdentifier, current []byte) []byte {
+		if extID.Equal(oid) {
+			return ext
+		}
+		return current
+	})
+}
+
+// editTBS re-encodes a DER TBSCertificate, replacing the issuer if rawIssuer
+// isn't nil, and each extension with what editExt returns for it, dropping
+// those it returns nil for.
+func editTBS(tbs []byte, rawIssuer []byte, editExt func(asn1.ObjectIdentifier, []byte) []byte) ([]byte, error) {
+	var tbsSeq asn1.RawValue
+	if rest, err := asn1.Unmarshal(tbs, &tbsSeq); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("malformed TBSCertificate")
+	}
+
+	var fields bytes.Buffer
+	remaining := tbsSeq.Bytes
+	// version [0], if present, then serialNumber, signature and issuer
+	issuerIndex := 2
+	for index := 0; len(remaining) > 0; index++ {
+		var field asn1.RawValue
+		var err error
+		remaining, err = asn1.Unmarshal(remaining, &field)
+		if err != nil {
+			return nil, err
+		}
+
+		if index == 0 && field.Class == asn1.ClassContextSpecific && field.Tag == 0 {
+			issuerIndex = 3
+		}
+		if index == issuerIndex && rawIssuer != nil {
+			fields.Write(rawIssuer)
+			continue
+		}
+
+		// extensions [3] EXPLICIT Extensions
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 3 {
+			fields.Write(field.FullBytes)
+			continue
+		}
+
+		var extSeq asn1.RawValue
+		if _, err = asn1.Unmarshal(field.Bytes, &extSeq); err != nil {
+			return nil, err
+		}
+
+		var exts bytes.Buffer
+		extRemaining := extSeq.Bytes
+		for len(extRemaining) > 0 {
+			var ext asn1.RawValue
+			extRemaining, err = asn1.Unmarshal(extRemaining, &ext)
+			if err != nil {
+				return nil, err
+			}
+			var extID asn1.ObjectIdentifier
+			if _, err = asn1.Unmarshal(ext.Bytes, &extID); err != nil {
+				return nil, err
+			}
+			exts.Write(editExt(extID, ext.FullBytes))
+		}
+
+		if exts.Len() > 0 {
+			fields.Write(encodeDER(asn1.ClassContextSpecific, 3, true,
+				encodeDER(asn1.ClassUniversal, asn1.TagSequence, true, exts.Bytes())))
+		}
+	}
+
+	return encodeDER(asn1.ClassUniversal, asn1.TagSequence, true, fields.Bytes()), nil
+}
+
+func encodeDER(class int, tag int, compound bool, content []byte) []byte {
+	identifier := byte(class<<6) | byte(tag)
+	if compound {
+		identifier |= 0x20
+	}
+
+	var out bytes.Buffer
+	out.WriteByte(identifier)
+	if len(content) < 0x80 {
+		out.WriteByte(byte(len(content)))
+	} else {
+		var length []byte
+		for l := len(content); l > 0; l >>= 8 {
+			length = append([]byte{byte(l)}, length...)
+		}
+		out.WriteByte(0x80 | byte(len(length)))
+		out.Write(length)
+	}
+	out.Write(content)
+	return out.Bytes()
+}
@@ -73,3 +73,30 @@ func (db *FolderDatabase) Get(aID uint64) ([]byte, error) {
 	_, fileName := db.getPathForID(aID)
 	return ioutil.ReadFile(fileName)
 }
+
+func (db *FolderDatabase) getChainPathForID(aID uint64) (string, string) {
+	dirPath, filePath := db.getPathForID(aID)
+	return dirPath, filePath + ".chain"
+}
+
+// StoreChain keeps the chain a certificate was logged with, alongside it
+func (db *FolderDatabase) StoreChain(aID uint64, aData []byte) error {
+	dirPath, filePath := db.getChainPathForID(aID)
+	if !isDirectory(dirPath) {
+		err := os.Mkdir(dirPath, os.ModeDir|0777)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := os.Stat(filePath)
+	if err != nil && os.IsNotExist(err) {
+		return ioutil.WriteFile(filePath, aData, db.permissions)
+	}
+	// Already exists, so skip
+	return nil
+}
+
+func (db *FolderDatabase) GetChain(aID uint64) ([]byte, error) {
+	_, fileName := db.getChainPathForID(aID)
+	return ioutil.ReadFile(fileName)
+}
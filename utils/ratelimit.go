@@ -0,0 +1,80 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a simple token-bucket rate limiter. It refills at rate
+// tokens per second, holding no more than burst tokens at once.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+// NewTokenBucket returns a bucket permitting rate operations per second. A
+// rate of zero or less produces a bucket that never blocks.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token, returning how long the caller must wait before
+// the token is actually available.
+func (tb *TokenBucket) reserve() time.Duration {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	if tb.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// Wait blocks until a token is available.
+func (tb *TokenBucket) Wait() {
+	if tb == nil {
+		return
+	}
+	if delay := tb.reserve(); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Drain empties the bucket, so that the next Wait is delayed by at least
+// the refill time. Used when the remote side tells us to slow down.
+func (tb *TokenBucket) Drain() {
+	if tb == nil {
+		return
+	}
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	tb.tokens = 0
+	tb.last = time.Now()
+}
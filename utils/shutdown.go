@@ -0,0 +1,42 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package utils
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// SignalContext returns the root context for a command, cancelled when SIGINT
+// or SIGTERM is caught so that work stops, drains and saves its state. If the
+// process hasn't exited drainTimeout after that, or a second signal is
+// caught, it exits immediately.
+func SignalContext(drainTimeout time.Duration) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		sig := <-sigChan
+		log.Printf("Signal caught: %s. Stopping, and exiting within %s.", sig, drainTimeout)
+		cancel()
+
+		select {
+		case sig = <-sigChan:
+			log.Printf("Signal caught: %s. Exiting now.", sig)
+		case <-time.After(drainTimeout):
+			log.Printf("Unable to stop within %s. Exiting now.", drainTimeout)
+		}
+		os.Exit(1)
+	}()
+
+	return ctx
+}
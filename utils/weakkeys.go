@@ -0,0 +1,212 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// DebianWeakKeys holds the fingerprints of the RSA moduli generated by
+// Debian's broken OpenSSL (CVE-2008-0166), as listed by openssl-blacklist.
+type DebianWeakKeys map[string]struct{}
+
+// LoadDebianWeakKeys reads blocklist files in the openssl-blacklist format:
+// per line, the last 20 hex digits of the SHA-1 of "Modulus=<HEX>\n", with
+// lines starting with # ignored.
+func LoadDebianWeakKeys(paths []string) (DebianWeakKeys, error) {
+	keys := make(DebianWeakKeys)
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if len(line) != 20 {
+				file.Close()
+				return nil, fmt.Errorf("%s: unexpected fingerprint %q", path, line)
+			}
+			keys[strings.ToLower(line)] = struct{}{}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// Contains reports whether the modulus is on a loaded blocklist.
+func (dwk DebianWeakKeys) Contains(modulus *big.Int) bool {
+	digest := sha1.Sum([]byte(fmt.Sprintf("Modulus=%X\n", modulus)))
+	_, ok := dwk[hex.EncodeToString(digest[:])[20:]]
+	return ok
+}
+
+// ROCA (CVE-2017-15361) primes are 65537^a mod M plus a multiple of M, where
+// M is a primorial, so the modulus of a vulnerable key is, modulo each of
+// the small primes dividing M, in the subgroup generated by 65537.
+var (
+	rocaPrimes = []int64{3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71,
+		73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131, 137, 139, 149, 151, 157, 163, 167}
+	rocaSubgroups = makeROCASubgroups()
+)
+
+func makeROCASubgroups() []map[int64]bool {
+	subgroups := make([]map[int64]bool, len(rocaPrimes))
+	for i, p := range rocaPrimes {
+		subgroup := make(map[int64]bool)
+		generator := 65537 % p
+		for r := int64(1); !subgroup[r]; r = r * generator % p {
+			subgroup[r] = true
+		}
+		subgroups[i] = subgroup
+	}
+	return subgroups
+}
+
+// IsROCAVulnerable applies the ROCA fingerprint test to the modulus.
+func IsROCAVulnerable(modulus *big.Int) bool {
+	residue := new(big.Int)
+	for i, p := range rocaPrimes {
+		residue.Mod(modulus, big.NewInt(p))
+		if !rocaSubgroups[i][residue.Int64()] {
+			return false
+		}
+	}
+	return true
+}
+
+// productTree returns the levels of the product tree over the moduli,
+// leaves first, so the root is its last level's only node.
+func productTree(moduli []*big.Int) [][]*big.Int {
+	tree := [][]*big.Int{moduli}
+	for level := moduli; len(level) > 1; {
+		next := make([]*big.Int, (len(level)+1)/2)
+		for i := range next {
+			if 2*i+1 < len(level) {
+				next[i] = new(big.Int).Mul(level[2*i], level[2*i+1])
+			} else {
+				next[i] = level[2*i]
+			}
+		}
+		tree = append(tree, next)
+		level = next
+	}
+	return tree
+}
+
+// BatchGCD returns, for each modulus, its GCD with the product of all the
+// others, using product and remainder trees. Moduli should be distinct, as
+// duplicates share every factor. Any result other than 1 is a shared factor;
+// one equal to the modulus means both its factors are shared.
+func BatchGCD(moduli []*big.Int) []*big.Int {
+	if len(moduli) == 0 {
+		return nil
+	}
+	tree := productTree(moduli)
+
+	// Remainder tree, reducing the product modulo each node squared
+	remainders := tree[len(tree)-1]
+	for depth := len(tree) - 2; depth >= 0; depth-- {
+		level := tree[depth]
+		next := make([]*big.Int, len(level))
+		for i, node := range level {
+			square := new(big.Int).Mul(node, node)
+			next[i] = new(big.Int).Mod(remainders[i/2], square)
+		}
+		remainders = next
+	}
+
+	gcds := make([]*big.Int, len(moduli))
+	for i, modulus := range moduli {
+		quotient := new(big.Int).Div(remainders[i], modulus)
+		if quotient.Sign() == 0 {
+			// The others' product is a multiple of the modulus
+			gcds[i] = new(big.Int).Set(modulus)
+			continue
+		}
+		gcds[i] = new(big.Int).GCD(nil, nil, quotient, modulus)
+	}
+	return gcds
+}
+
+// productGCDs returns, for each modulus, its GCD with the product, reducing
+// the product down the moduli's product tree.
+func productGCDs(tree [][]*big.Int, product *big.Int) []*big.Int {
+	remainders := []*big.Int{new(big.Int).Mod(product, tree[len(tree)-1][0])}
+	for depth := len(tree) - 2; depth >= 0; depth-- {
+		level := tree[depth]
+		next := make([]*big.Int, len(level))
+		for i, node := range level {
+			next[i] = new(big.Int).Mod(remainders[i/2], node)
+		}
+		remainders = next
+	}
+
+	gcds := make([]*big.Int, len(remainders))
+	for i, modulus := range tree[0] {
+		gcds[i] = new(big.Int).GCD(nil, nil, remainders[i], modulus)
+	}
+	return gcds
+}
+
+// lcm of two divisors of an RSA modulus, combining the factors each found
+func lcm(a, b *big.Int) *big.Int {
+	gcd := new(big.Int).GCD(nil, nil, a, b)
+	return new(big.Int).Div(new(big.Int).Mul(a, b), gcd)
+}
+
+// GroupedBatchGCD returns the same as BatchGCD, but bounds the memory used by
+// running it over groups of at most groupSize moduli, then checking each
+// group against the product of every other, a pair of groups at a time. A
+// groupSize of zero runs one BatchGCD over them all.
+func GroupedBatchGCD(moduli []*big.Int, groupSize int) []*big.Int {
+	if groupSize <= 0 || len(moduli) <= groupSize {
+		return BatchGCD(moduli)
+	}
+
+	var groups [][]*big.Int
+	for start := 0; start < len(moduli); start += groupSize {
+		end := start + groupSize
+		if end > len(moduli) {
+			end = len(moduli)
+		}
+		groups = append(groups, moduli[start:end])
+	}
+
+	products := make([]*big.Int, len(groups))
+	for i, group := range groups {
+		tree := productTree(group)
+		products[i] = tree[len(tree)-1][0]
+	}
+
+	gcds := make([]*big.Int, 0, len(moduli))
+	for i, group := range groups {
+		found := BatchGCD(group)
+		tree := productTree(group)
+		for j, product := range products {
+			if j == i {
+				continue
+			}
+			for k, gcd := range productGCDs(tree, product) {
+				found[k] = lcm(found[k], gcd)
+			}
+		}
+		gcds = append(gcds, found...)
+	}
+	return gcds
+}